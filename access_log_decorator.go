@@ -6,16 +6,59 @@ import (
 	"time"
 	"io"
 	"github.com/sirupsen/logrus"
+	"fmt"
+	"os"
+	"sync"
 	"strconv"
 	"encoding/json"
 )
 
+// legacyTimeLayout is the layout of the time fields in the default text format.
+const legacyTimeLayout = "2006-01-02 03:04:05.999999999"
+
 type AccessLogDecorator struct {
 	http.Handler
 	loggingHeaders      []string
 	rowFillerContextKey interface{}
 	rowFillerFactory    AccessLogRowFillerFactory
 	logger              *logrus.Logger
+	location            *time.Location
+	textFormat          bool
+}
+
+// AccessLogOption customizes an AccessLogDecorator.
+type AccessLogOption func(*AccessLogDecorator)
+
+// WithAccessLogFormatter sets the format of the rows, e.g. LogfmtFormatter, JSONLinesFormatter,
+// ApacheCombinedFormatter or W3CExtendedFormatter. Formatters with a 'Header() []byte' method get the header written
+// when the decorator is created. The rows given to the formatter keep the types of their values and have the bytes,
+// proto, referer and userAgent fields. Without this option the rows are written by the text formatter of logrus with
+// every value as a string, as before the option was added.
+func WithAccessLogFormatter(formatter logrus.Formatter) AccessLogOption {
+	return func(d *AccessLogDecorator) {
+		d.logger.Formatter = formatter
+		d.textFormat = false
+	}
+}
+
+// WithAccessLogLocation sets the time zone of the time fields, time.Local by default.
+func WithAccessLogLocation(location *time.Location) AccessLogOption {
+	return func(d *AccessLogDecorator) {
+		d.location = location
+	}
+}
+
+// lockedWriter serializes the writes of the logger and the header. The lock of logrus is unexported, so the logger
+// runs without it and its rows are written under this one.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *lockedWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(data)
 }
 
 type AccessLogRow struct {
@@ -29,9 +72,15 @@ func (row *AccessLogRow) SetRowField(field string, value string) {
 	row.fields[field] = value
 }
 
+// SetRowValue sets a field keeping its type, so numbers are not quoted and times are formatted by the formatter.
+func (row *AccessLogRow) SetRowValue(field string, value interface{}) {
+	row.fields[field] = value
+}
+
 type statusResponseWriter struct {
 	http.ResponseWriter
 	status int
+	bytes  int64
 }
 
 func (w *statusResponseWriter) WriteHeader(status int) {
@@ -39,18 +88,52 @@ func (w *statusResponseWriter) WriteHeader(status int) {
 	w.ResponseWriter.WriteHeader(status)
 }
 
+func (w *statusResponseWriter) Write(data []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(data)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets the methods bypassing the response body stream it.
+func (w *statusResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the hijacking and the deadlines of the underlying writer.
+func (w *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 func NewAccessLogDecorator(handler http.Handler, logWriter io.Writer, loggingHeaders []string,
-	rowFillerContextKey interface{}, rowFillerFactory AccessLogRowFillerFactory) *AccessLogDecorator {
+	rowFillerContextKey interface{}, rowFillerFactory AccessLogRowFillerFactory,
+	opts ...AccessLogOption) *AccessLogDecorator {
 	logger := logrus.New()
 	logger.Formatter = &logrus.TextFormatter{DisableTimestamp: true}
-	logger.Out = logWriter
-	return &AccessLogDecorator{
+	logger.Out = &lockedWriter{w: logWriter}
+	logger.SetNoLock()
+	d := &AccessLogDecorator{
 		handler,
 		loggingHeaders,
 		rowFillerContextKey,
 		rowFillerFactory,
 		logger,
+		time.Local,
+		true,
 	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	if formatter, ok := d.logger.Formatter.(interface{ Header() []byte }); ok {
+		if _, err := logger.Out.Write(formatter.Header()); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write access log header, %v\n", err)
+		}
+	}
+
+	return d
 }
 
 func (d *AccessLogDecorator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -67,6 +150,7 @@ func (d *AccessLogDecorator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	sw := &statusResponseWriter{
 		w,
 		http.StatusOK,
+		0,
 	}
 
 	d.Handler.ServeHTTP(sw, r)
@@ -75,18 +159,31 @@ func (d *AccessLogDecorator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	for _, k := range d.loggingHeaders {
 		headers[k] = r.Header[k]
 	}
-	marshaledHeaders, err := json.Marshal(headers)
-	if err != nil {
-		panic(err)
-	}
 
-	row.SetRowField("beginTime", beginTime.Format("2006-01-02 03:04:05.999999999"))
-	row.SetRowField("status", strconv.Itoa(sw.status))
-	row.SetRowField("duration", strconv.FormatFloat(time.Now().Sub(beginTime).Seconds(), 'f', -1, 64))
+	row.SetRowValue("beginTime", beginTime)
+	row.SetRowValue("status", sw.status)
+	row.SetRowValue("duration", time.Now().Sub(beginTime).Seconds())
 	row.SetRowField("remote", r.RemoteAddr)
 	row.SetRowField("httpMethod", r.Method)
 	row.SetRowField("uri", r.URL.RequestURI())
-	row.SetRowField("headers", string(marshaledHeaders))
+	row.SetRowValue("headers", headers)
+	if !d.textFormat {
+		row.SetRowValue("bytes", sw.bytes)
+		row.SetRowField("proto", r.Proto)
+		row.SetRowField("referer", r.Referer())
+		row.SetRowField("userAgent", r.UserAgent())
+	}
+
+	for field, value := range row.fields {
+		if t, ok := value.(time.Time); ok {
+			value = t.In(d.location)
+			row.fields[field] = value
+		}
+
+		if d.textFormat {
+			row.fields[field] = textFieldValue(value)
+		}
+	}
 
 	if sw.status < http.StatusBadRequest {
 		d.logger.WithFields(row.fields).Info()
@@ -94,3 +191,26 @@ func (d *AccessLogDecorator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		d.logger.WithFields(row.fields).Error()
 	}
 }
+
+// textFieldValue converts the typed values to the strings the default text format has always written.
+func textFieldValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format(legacyTimeLayout)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case map[string][]string:
+		marshaled, err := json.Marshal(v)
+		if err != nil {
+			panic(err)
+		}
+
+		return string(marshaled)
+	default:
+		return value
+	}
+}
@@ -0,0 +1,93 @@
+package kellyframework
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serveLogged(t *testing.T, opts ...AccessLogOption) string {
+	router, err := NewHTTPRouter([]*Route{{Method: "GET", Path: "/hello/:Name", Function: middlewareFunction}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	decorator := NewAccessLogDecorator(router, buf, []string{"X-Request-Id"},
+		ServiceHandlerAccessLogRowFillerContextKey, ServiceHandlerAccessLogRowFillerFactory,
+		append(opts, WithAccessLogLocation(time.FixedZone("CST", 8*3600)))...)
+	req := httptest.NewRequest("GET", "/hello/kelly", nil)
+	req.Header.Set("User-Agent", "test agent")
+	req.Header.Set("X-Request-Id", "abc")
+	decorator.ServeHTTP(httptest.NewRecorder(), req)
+	return buf.String()
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	row := map[string]interface{}{}
+	line := serveLogged(t, WithAccessLogFormatter(JSONLinesFormatter{}))
+	if err := json.Unmarshal([]byte(line), &row); err != nil {
+		t.Fatal("json line is wrong:", line)
+	}
+
+	if row["status"] != float64(200) || row["bytes"] != float64(len(`"method(kelly)"`+"\n")) ||
+		row["level"] != "info" || row["userAgent"] != "test agent" ||
+		!strings.HasSuffix(row["beginTime"].(string), "+08:00") ||
+		!strings.HasSuffix(row["methodCallBeginTime"].(string), "+08:00") {
+		t.Error("json line is wrong:", line)
+	}
+
+	if _, ok := row["duration"].(float64); !ok {
+		t.Error("duration is not a number:", line)
+	}
+
+	if headers, ok := row["headers"].(map[string]interface{}); !ok || headers["X-Request-Id"] == nil {
+		t.Error("headers are wrong:", line)
+	}
+
+	line = serveLogged(t, WithAccessLogFormatter(LogfmtFormatter{}))
+	if !regexp.MustCompile(`^level=info beginTime=\S+\+08:00 bytes=16 duration=[0-9.e-]+ `+
+		`headers="{\\"X-Request-Id\\":\[\\"abc\\"\]}" httpMethod=GET `).MatchString(line) ||
+		!strings.Contains(line, " status=200 ") || !strings.Contains(line, ` userAgent="test agent"`) {
+		t.Error("logfmt line is wrong:", line)
+	}
+
+	line = serveLogged(t, WithAccessLogFormatter(ApacheCombinedFormatter{}))
+	if !regexp.MustCompile(`^192\.0\.2\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} \+0800\] ` +
+		`"GET /hello/kelly HTTP/1\.1" 200 16 "-" "test agent"\n$`).MatchString(line) {
+		t.Error("apache combined line is wrong:", line)
+	}
+
+	line = serveLogged(t, WithAccessLogFormatter(W3CExtendedFormatter{}))
+	if !regexp.MustCompile(`^#Version: 1\.0\n#Fields: date time .*\n` +
+		`\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3} 192\.0\.2\.1 GET /hello/kelly 200 16 \d+\.\d{3} test\+agent -\n$`).
+		MatchString(line) {
+		t.Error("w3c extended line is wrong:", line)
+	}
+}
+
+func TestAccessLogDefaultFormat(t *testing.T) {
+	line := serveLogged(t)
+	if !regexp.MustCompile(`^level=info beginTime="\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}(\.\d+)?" duration=[0-9.]+ `+
+		`headers="{\\"X-Request-Id\\":\[\\"abc\\"\]}" httpMethod=GET `).MatchString(line) ||
+		!strings.Contains(line, ` methodCallBeginTime="`) || !strings.Contains(line, " status=200 ") ||
+		strings.Contains(line, "bytes=") || strings.Contains(line, "userAgent=") {
+		t.Error("text line is wrong:", line)
+	}
+}
+
+func TestW3CValue(t *testing.T) {
+	for value, expected := range map[string]string{
+		"":                      "-",
+		"test agent":            "test+agent",
+		"a\tb\r\nc\x00d\u2028e": "a%09b%0D%0Ac%00d%E2%80%A8e",
+	} {
+		if actual := w3cValue(value); actual != expected {
+			t.Errorf("expected %s, got %s", expected, actual)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package kellyframework
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/sirupsen/logrus"
+)
+
+// JSONLinesFormatter writes every access log row as a line of JSON, numeric fields stay numbers and times are
+// RFC3339Nano strings.
+type JSONLinesFormatter struct{}
+
+func (JSONLinesFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	data := make(map[string]interface{}, len(entry.Data)+1)
+	for k, v := range entry.Data {
+		data[k] = formatTimeField(v)
+	}
+
+	data["level"] = entry.Level.String()
+	line, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(line, '\n'), nil
+}
+
+// LogfmtFormatter writes every access log row as sorted key=value pairs.
+type LogfmtFormatter struct{}
+
+func (LogfmtFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteString("level=" + entry.Level.String())
+	keys := make([]string, 0, len(entry.Data))
+	for k := range entry.Data {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	for _, k := range keys {
+		value, err := logfmtValue(entry.Data[k])
+		if err != nil {
+			return nil, err
+		}
+
+		buf.WriteString(" " + k + "=" + value)
+	}
+
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+func formatTimeField(v interface{}) interface{} {
+	if t, ok := v.(time.Time); ok {
+		return t.Format(time.RFC3339Nano)
+	}
+
+	return v
+}
+
+func logfmtValue(v interface{}) (string, error) {
+	var s string
+	switch value := formatTimeField(v).(type) {
+	case string:
+		s = value
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, bool:
+		return fmt.Sprint(value), nil
+	case float32:
+		return strconv.FormatFloat(float64(value), 'f', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case error:
+		s = value.Error()
+	default:
+		data, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+
+		s = string(data)
+	}
+
+	if s == "" || strings.ContainsAny(s, " =\"\\\t\r\n") {
+		return strconv.Quote(s), nil
+	}
+
+	return s, nil
+}
+
+func stringField(entry *logrus.Entry, field string) string {
+	s, _ := entry.Data[field].(string)
+	return s
+}
+
+func clientHost(remote string) string {
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		return remote
+	}
+
+	return host
+}
+
+// apacheQuote escapes a request line or header value the way Apache httpd does.
+func apacheQuote(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`).Replace(s) + `"`
+}
+
+// ApacheCombinedFormatter writes access log rows in the NCSA combined log format of Apache httpd.
+type ApacheCombinedFormatter struct{}
+
+func (ApacheCombinedFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	beginTime, _ := entry.Data["beginTime"].(time.Time)
+	bytesField := "-"
+	if n, ok := entry.Data["bytes"].(int64); ok && n > 0 {
+		bytesField = strconv.FormatInt(n, 10)
+	}
+
+	line := fmt.Sprintf("%s - - [%s] %s %v %s %s %s\n", clientHost(stringField(entry, "remote")),
+		beginTime.Format("02/Jan/2006:15:04:05 -0700"),
+		apacheQuote(stringField(entry, "httpMethod")+" "+stringField(entry, "uri")+" "+stringField(entry, "proto")),
+		entry.Data["status"], bytesField, apacheQuote(stringField(entry, "referer")),
+		apacheQuote(stringField(entry, "userAgent")))
+	return []byte(line), nil
+}
+
+// W3CExtendedFormatter writes access log rows in the W3C extended log file format. The specification asks for
+// GMT, so the times are in UTC whatever the location of the decorator is.
+type W3CExtendedFormatter struct{}
+
+const w3cExtendedFields = "date time c-ip cs-method cs-uri sc-status sc-bytes time-taken cs(User-Agent) cs(Referer)"
+
+// Header returns the directives written once before the first row.
+func (W3CExtendedFormatter) Header() []byte {
+	return []byte("#Version: 1.0\n#Fields: " + w3cExtendedFields + "\n")
+}
+
+// w3cValue uses '-' for missing values. Spaces become '+' because the fields are separated by spaces, the other
+// whitespace and control characters are percent encoded, so a value can not break the line or forge fields.
+func w3cValue(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	buf := &strings.Builder{}
+	for _, r := range s {
+		switch {
+		case r == ' ':
+			buf.WriteByte('+')
+		case unicode.IsSpace(r) || unicode.IsControl(r):
+			for _, b := range []byte(string(r)) {
+				fmt.Fprintf(buf, "%%%02X", b)
+			}
+		default:
+			buf.WriteRune(r)
+		}
+	}
+
+	return buf.String()
+}
+
+func (W3CExtendedFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	beginTime, _ := entry.Data["beginTime"].(time.Time)
+	beginTime = beginTime.UTC()
+	bytesField := "-"
+	if n, ok := entry.Data["bytes"].(int64); ok {
+		bytesField = strconv.FormatInt(n, 10)
+	}
+
+	duration, _ := entry.Data["duration"].(float64)
+	line := fmt.Sprintf("%s %s %s %s %s %v %s %s %s %s\n", beginTime.Format("2006-01-02"),
+		beginTime.Format("15:04:05.000"), w3cValue(clientHost(stringField(entry, "remote"))),
+		w3cValue(stringField(entry, "httpMethod")), w3cValue(stringField(entry, "uri")), entry.Data["status"],
+		bytesField, strconv.FormatFloat(duration, 'f', 3, 64), w3cValue(stringField(entry, "userAgent")),
+		w3cValue(stringField(entry, "referer")))
+	return []byte(line), nil
+}
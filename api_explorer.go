@@ -0,0 +1,253 @@
+package kellyframework
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// APIExplorer is a self-contained HTML page listing routes and their argument fields, from which test requests
+// can be sent. Its styles and scripts are embedded in the page, so it works without any network access.
+type APIExplorer struct {
+	page []byte
+}
+
+type explorerField struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	Type   string `json:"type"`
+	Rules  string `json:"rules"`
+}
+
+type explorerRoute struct {
+	Method    string           `json:"method"`
+	Path      string           `json:"path"`
+	Function  string           `json:"function"`
+	Multipart bool             `json:"multipart"`
+	Fields    []*explorerField `json:"fields"`
+}
+
+type explorerData struct {
+	Title  string
+	Routes []*explorerRoute
+}
+
+// the source shown for file fields, they are sent as parts of a multipart body.
+const explorerFileSource = "file"
+
+func NewAPIExplorer(title string, routes []*Route) (*APIExplorer, error) {
+	data := &explorerData{title, nil}
+	for _, rt := range routes {
+		route, err := newExplorerRoute(rt)
+		if err != nil {
+			return nil, err
+		}
+
+		data.Routes = append(data.Routes, route)
+	}
+
+	buf := &bytes.Buffer{}
+	err := explorerTemplate.Execute(buf, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &APIExplorer{buf.Bytes()}, nil
+}
+
+func newExplorerRoute(rt *Route) (*explorerRoute, error) {
+	methodType := reflect.TypeOf(rt.Function)
+	err := checkServiceMethodPrototype(methodType)
+	if err != nil {
+		return nil, err
+	}
+
+	binding, err := newArgumentBinding(methodType.In(1).Elem())
+	if err != nil {
+		return nil, err
+	}
+
+	route := &explorerRoute{strings.ToUpper(rt.Method), rt.Path, functionName(rt.Function), false, nil}
+	for _, f := range binding.fields {
+		if f.source != SourceBody {
+			route.Fields = append(route.Fields, &explorerField{f.name, f.source, f.field.Type.String(),
+				f.field.Tag.Get("validate")})
+		}
+	}
+
+	var pathParams []string
+	for _, match := range httprouterParamPattern.FindAllStringSubmatch(rt.Path, -1) {
+		pathParams = append(pathParams, match[1])
+	}
+
+	// body fields are sent as parts of the multipart body with their form names when the argument has files.
+	for _, field := range binding.settable {
+		route.Multipart = route.Multipart || isFileType(field.Type)
+	}
+
+	for _, field := range binding.settable {
+		f := &explorerField{formFieldName(field), SourceQuery, field.Type.String(), field.Tag.Get("validate")}
+		if requestHasBody(rt) || isBodyBound(field) {
+			f.Source = SourceBody
+			if !route.Multipart {
+				f.Name = jsonFieldName(field)
+			}
+		}
+
+		for _, param := range pathParams {
+			if strings.EqualFold(param, formFieldName(field)) && !isBodyBound(field) {
+				f.Name, f.Source = param, SourcePath
+			}
+		}
+
+		if isFileType(field.Type) {
+			f.Name, f.Source = formFieldName(field), explorerFileSource
+		}
+
+		route.Fields = append(route.Fields, f)
+	}
+
+	// path params not matching any field still have to be filled in.
+	for _, param := range pathParams {
+		found := false
+		for _, f := range route.Fields {
+			found = found || (f.Source == SourcePath && strings.EqualFold(f.Name, param))
+		}
+
+		if !found {
+			route.Fields = append(route.Fields, &explorerField{param, SourcePath, "string", ""})
+		}
+	}
+
+	return route, nil
+}
+
+func (e *APIExplorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("x-content-type-options", "nosniff")
+	w.Write(e.page)
+}
+
+var explorerTemplate = template.Must(template.New("explorer").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+.route { border: 1px solid #ccc; border-radius: 4px; margin-bottom: 1em; }
+.route > summary { padding: .5em; cursor: pointer; background: #f5f5f5; }
+.method { display: inline-block; min-width: 5em; font-weight: bold; }
+.function { color: #888; margin-left: 1em; }
+.route form { padding: .5em 1em; }
+table { border-collapse: collapse; width: 100%; }
+td, th { text-align: left; padding: .2em .5em; border-bottom: 1px solid #eee; }
+input[type=text] { width: 100%; box-sizing: border-box; }
+pre { background: #f5f5f5; padding: .5em; white-space: pre-wrap; word-break: break-all; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range $i, $route := .Routes}}
+<details class="route">
+<summary><span class="method">{{.Method}}</span>{{.Path}}<span class="function">{{.Function}}</span></summary>
+<form data-route="{{$i}}">
+<table>
+<tr><th>field</th><th>source</th><th>type</th><th>rules</th><th>value</th></tr>
+{{range $j, $field := .Fields}}
+<tr><td>{{.Name}}</td><td>{{.Source}}</td><td>{{.Type}}</td><td>{{.Rules}}</td>
+<td>{{if eq .Source "file"}}<input type="file" name="{{$j}}" multiple>{{else}}<input type="text" name="{{$j}}">{{end}}</td></tr>
+{{end}}
+</table>
+<p><button type="submit">send</button></p>
+<pre class="result"></pre>
+</form>
+</details>
+{{end}}
+<script>
+var routes = {{.Routes}};
+
+function convert(value) {
+	try {
+		return JSON.parse(value);
+	} catch (e) {
+		return value;
+	}
+}
+
+function send(form) {
+	var route = routes[form.getAttribute("data-route")];
+	var path = route.path, query = [], headers = {}, body = {}, hasBody = false;
+	var multipart = route.multipart ? new FormData() : null;
+	(route.fields || []).forEach(function (field, j) {
+		var input = form.elements[String(j)];
+		if (field.source === "file") {
+			for (var k = 0; k < input.files.length; k++) {
+				multipart.append(field.name, input.files[k]);
+			}
+			return;
+		}
+
+		if (input.value === "") {
+			return;
+		}
+
+		switch (field.source) {
+		case "path":
+			path = path.replace(new RegExp("[:*]" + field.name + "(?=/|$)"), encodeURIComponent(input.value));
+			break;
+		case "query":
+			query.push(encodeURIComponent(field.name) + "=" + encodeURIComponent(input.value));
+			break;
+		case "header":
+			headers[field.name] = input.value;
+			break;
+		case "cookie":
+			document.cookie = encodeURIComponent(field.name) + "=" + encodeURIComponent(input.value) + "; path=/";
+			break;
+		case "body":
+			hasBody = true;
+			if (multipart) {
+				multipart.append(field.name, input.value);
+			} else {
+				body[field.name] = convert(input.value);
+			}
+			break;
+		}
+	});
+
+	var init = {method: route.method, headers: headers, credentials: "same-origin"};
+	if (multipart) {
+		init.body = multipart;
+	} else if (hasBody) {
+		headers["Content-Type"] = "application/json";
+		init.body = JSON.stringify(body);
+	}
+
+	var result = form.querySelector(".result");
+	result.textContent = "...";
+	fetch(path + (query.length ? "?" + query.join("&") : ""), init).then(function (resp) {
+		return resp.text().then(function (text) {
+			try {
+				text = JSON.stringify(JSON.parse(text), null, 2);
+			} catch (e) {
+			}
+			result.textContent = resp.status + " " + resp.statusText + "\n" + text;
+		});
+	}).catch(function (err) {
+		result.textContent = String(err);
+	});
+}
+
+document.querySelectorAll("form[data-route]").forEach(function (form) {
+	form.addEventListener("submit", function (event) {
+		event.preventDefault();
+		send(form);
+	});
+});
+</script>
+</body>
+</html>
+`))
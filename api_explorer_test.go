@@ -0,0 +1,49 @@
+package kellyframework
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type explorerUploadArgument struct {
+	Title  string `json:"title" schema:"caption"`
+	Avatar *UploadedFile
+}
+
+func explorerUploadFunction(*ServiceMethodContext, *explorerUploadArgument) error {
+	return nil
+}
+
+func TestNewAPIExplorer(t *testing.T) {
+	explorer, err := NewAPIExplorer("users <test>", []*Route{
+		{Method: "POST", Path: "/user/:Name", Function: openAPIAddUser},
+		{Method: "GET", Path: "/user/:Name", Function: openAPIGetUser},
+		{Method: "POST", Path: "/upload", Function: uploadFunction},
+		{Method: "POST", Path: "/upload2", Function: explorerUploadFunction},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	explorer.ServeHTTP(recorder, httptest.NewRequest("GET", "/explorer", nil))
+	page := recorder.Body.String()
+	for _, expected := range []string{
+		"<title>users &lt;test&gt;</title>",
+		`{"name":"X-Request-Id","source":"header","type":"string","rules":""}`,
+		`{"name":"Name","source":"path","type":"string","rules":"required,max=32"}`,
+		`{"name":"age","source":"body","type":"int","rules":"min=0,max=140"}`,
+		`{"name":"Avatar","source":"file","type":"*kellyframework.UploadedFile","rules":"required"}`,
+		`"multipart":true`,
+		`{"name":"caption","source":"body","type":"string","rules":""}`,
+	} {
+		if !strings.Contains(page, expected) {
+			t.Error("page does not contain", expected)
+		}
+	}
+
+	if recorder.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Error("content type is wrong:", recorder.Header())
+	}
+}
@@ -0,0 +1,227 @@
+package kellyframework
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// the sources an argument field can be bound to with the 'kelly' struct tag, e.g. `kelly:"header=X-Request-Id"`.
+const (
+	SourceQuery  = "query"
+	SourcePath   = "path"
+	SourceBody   = "body"
+	SourceHeader = "header"
+	SourceCookie = "cookie"
+)
+
+// boundField is an argument field whose source is explicitly given by the 'kelly' tag, no other source can set it.
+type boundField struct {
+	index    []int
+	field    reflect.StructField
+	formName string
+	source   string
+	name     string
+}
+
+type argumentBinding struct {
+	argType reflect.Type
+	fields  []*boundField
+	// all the fields which can be set by query strings, bodies and path params, used to find conflicts.
+	settable []reflect.StructField
+}
+
+func parseSourceTag(field reflect.StructField) (source string, name string, err error) {
+	tag, ok := field.Tag.Lookup("kelly")
+	if !ok || tag == "" {
+		return "", "", nil
+	}
+
+	parts := strings.SplitN(tag, "=", 2)
+	source = strings.TrimSpace(parts[0])
+	name = field.Name
+	if len(parts) == 2 && strings.TrimSpace(parts[1]) != "" {
+		name = strings.TrimSpace(parts[1])
+	}
+
+	switch source {
+	case SourceQuery, SourcePath, SourceHeader, SourceCookie:
+	case SourceBody:
+		if len(parts) == 2 {
+			return "", "", fmt.Errorf("field %s: the body source does not take a name", field.Name)
+		}
+	default:
+		return "", "", fmt.Errorf("field %s: unknown argument source %q", field.Name, source)
+	}
+
+	return
+}
+
+func collectBoundFields(t reflect.Type, index []int, fields []*boundField) ([]*boundField, error) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldIndex := append(append([]int{}, index...), i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			var err error
+			fields, err = collectBoundFields(field.Type, fieldIndex, fields)
+			if err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		source, name, err := parseSourceTag(field)
+		if err != nil {
+			return nil, err
+		}
+
+		if source == "" {
+			continue
+		}
+
+		if field.PkgPath != "" {
+			return nil, fmt.Errorf("field %s: unexported fields can not be bound", field.Name)
+		}
+
+		fields = append(fields, &boundField{fieldIndex, field, formFieldName(field), source, name})
+	}
+
+	return fields, nil
+}
+
+func newArgumentBinding(argType reflect.Type) (*argumentBinding, error) {
+	fields, err := collectBoundFields(argType, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &argumentBinding{argType, fields, collectSettableFields(argType, nil)}, nil
+}
+
+func collectSettableFields(t reflect.Type, fields []reflect.StructField) []reflect.StructField {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			fields = collectSettableFields(field.Type, fields)
+			continue
+		}
+
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if source, _, _ := parseSourceTag(field); source != "" && source != SourceBody {
+			continue
+		}
+
+		fields = append(fields, field)
+	}
+
+	return fields
+}
+
+// settableFieldNames maps form or JSON keys to the Go names of the fields they set, names match case
+// insensitively like gorilla/schema and encoding/json do.
+func (b *argumentBinding) settableFieldNames(keys []string, byJSONName bool) []string {
+	var names []string
+	for _, key := range keys {
+		// nested paths like 'a.b' set the top level field 'a'.
+		key = strings.SplitN(key, ".", 2)[0]
+		for _, field := range b.settable {
+			name := formFieldName(field)
+			if byJSONName {
+				name = jsonFieldName(field)
+			}
+
+			if strings.EqualFold(key, name) {
+				names = append(names, field.Name)
+				break
+			}
+		}
+	}
+
+	return names
+}
+
+// filter removes the values which would set a field bound to another source.
+func (b *argumentBinding) filter(values url.Values, source string) url.Values {
+	if len(b.fields) == 0 {
+		return values
+	}
+
+	filtered := url.Values{}
+	for k, v := range values {
+		reserved := false
+		for _, f := range b.fields {
+			if f.source != source && strings.EqualFold(k, f.formName) {
+				reserved = true
+				break
+			}
+		}
+
+		if !reserved {
+			filtered[k] = v
+		}
+	}
+
+	return filtered
+}
+
+func (b *argumentBinding) sourceValues(f *boundField, r *http.Request, query url.Values,
+	params httprouter.Params) []string {
+	switch f.source {
+	case SourceQuery:
+		for k, v := range query {
+			if strings.EqualFold(k, f.name) {
+				return v
+			}
+		}
+	case SourcePath:
+		for _, param := range params {
+			if strings.EqualFold(param.Key, f.name) {
+				return []string{param.Value}
+			}
+		}
+	case SourceHeader:
+		return r.Header[http.CanonicalHeaderKey(f.name)]
+	case SourceCookie:
+		if cookie, err := r.Cookie(f.name); err == nil {
+			return []string{cookie.Value}
+		}
+	}
+
+	return nil
+}
+
+// apply sets the bound fields from their own sources, whatever the body has set them to. Values are converted
+// the same way gorilla/schema converts query strings.
+func (b *argumentBinding) apply(arg reflect.Value, r *http.Request, query url.Values,
+	params httprouter.Params) error {
+	for _, f := range b.fields {
+		if f.source == SourceBody {
+			continue
+		}
+
+		fv := arg.FieldByIndex(f.index)
+		fv.Set(reflect.Zero(f.field.Type))
+		values := b.sourceValues(f, r, query, params)
+		if len(values) == 0 {
+			continue
+		}
+
+		tmp := reflect.New(b.argType)
+		err := formDecoder.Decode(tmp.Interface(), url.Values{f.formName: values})
+		if err != nil {
+			return fmt.Errorf("%s %s: %s", f.source, f.name, err)
+		}
+
+		fv.Set(tmp.Elem().FieldByIndex(f.index))
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package kellyframework
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// BodyDecoder decodes the request body into the argument struct pointer.
+type BodyDecoder func(r *http.Request, arg interface{}) error
+
+const defaultMultipartMaxMemory = 32 << 20
+
+// JSONDecodeOptions controls the built-in JSON body decoder. UseNumber only matters for interface{} fields, it keeps
+// big integers like int64 IDs from being converted to float64.
+type JSONDecodeOptions struct {
+	DisallowUnknownFields bool
+	RejectTrailingData    bool
+	UseNumber             bool
+}
+
+var errTrailingData = errors.New("unexpected data after the JSON value")
+
+func WithJSONDecodeOptions(opts JSONDecodeOptions) HandlerOption {
+	return func(h *ServiceHandler) {
+		h.jsonDecodeOptions = opts
+	}
+}
+
+// WithMaxBodySize limits the request body to maxSize bytes, a larger body gets 413. The limit also applies to the
+// body read by the service method itself through ServiceMethodContext.RequestBodyReader.
+func WithMaxBodySize(maxSize int64) HandlerOption {
+	return func(h *ServiceHandler) {
+		h.maxBodySize = maxSize
+	}
+}
+
+func (h *ServiceHandler) decodeJSONBody(r *http.Request, arg interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	if h.jsonDecodeOptions.DisallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+
+	if h.jsonDecodeOptions.UseNumber {
+		decoder.UseNumber()
+	}
+
+	err := decoder.Decode(arg)
+	if err != nil {
+		return err
+	}
+
+	if h.jsonDecodeOptions.RejectTrailingData {
+		_, err := decoder.Token()
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return err
+		} else if err != io.EOF {
+			return errTrailingData
+		}
+	}
+
+	return nil
+}
+
+func decodeFormBody(r *http.Request, arg interface{}) error {
+	err := r.ParseForm()
+	if err != nil {
+		return err
+	}
+
+	return formDecoder.Decode(arg, r.PostForm)
+}
+
+func (h *ServiceHandler) defaultBodyDecoders() map[string]BodyDecoder {
+	return map[string]BodyDecoder{
+		"application/json":                  h.decodeJSONBody,
+		"application/x-www-form-urlencoded": decodeFormBody,
+		"multipart/form-data":               h.decodeMultipartBody,
+	}
+}
+
+// WithBodyDecoder registers a decoder for the request bodies of the media type, e.g. 'application/xml'. It replaces
+// the built-in decoder if the media type is one of 'application/json', 'application/x-www-form-urlencoded' and
+// 'multipart/form-data'.
+func WithBodyDecoder(mediaType string, decoder BodyDecoder) HandlerOption {
+	mediaType = strings.ToLower(mediaType)
+	return func(h *ServiceHandler) {
+		h.bodyDecoders[mediaType] = decoder
+	}
+}
+
+func (h *ServiceHandler) decodeBody(r *http.Request, arg interface{}) error {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		// nothing tells how to decode the body, leave it alone.
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return err
+	}
+
+	decoder, ok := h.bodyDecoders[mediaType]
+	if !ok && strings.HasSuffix(mediaType, "+json") {
+		// structured syntax suffix, e.g. 'application/merge-patch+json'.
+		decoder, ok = h.bodyDecoders["application/json"]
+	}
+
+	if !ok {
+		if r.ContentLength == 0 {
+			return nil
+		}
+
+		return NewServiceMethodError(http.StatusUnsupportedMediaType, "unsupported media type", mediaType)
+	}
+
+	err = decoder(r, arg)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return WrapServiceMethodError(err, http.StatusRequestEntityTooLarge, "request body too large")
+	}
+
+	return err
+}
@@ -0,0 +1,279 @@
+package kellyframework
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+// Client sends the requests of the clients generated by GenerateClient.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	// Header is added to every request.
+	Header http.Header
+}
+
+// ClientRequest is one call of a service method, built by the generated clients.
+type ClientRequest struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	// Body is JSON encoded when it is not nil.
+	Body interface{}
+	// Form and Files are sent as a multipart body instead of Body when Files is not nil.
+	Form  url.Values
+	Files map[string][]*ClientFile
+}
+
+// ClientFile is a file field of a generated client argument, it is sent as a part of a multipart body.
+type ClientFile struct {
+	Filename string
+	// ContentType is 'application/octet-stream' when it is empty.
+	ContentType string
+	Content     io.Reader
+}
+
+// ClientError is a failure response of a kellyframework service decoded from the FormattedResponse shape. It
+// implements ServiceMethodError, so a service returning it passes the failure on unchanged.
+type ClientError struct {
+	Status int
+	Msg    string
+	Data   json.RawMessage
+}
+
+func (e *ClientError) Error() string {
+	if len(e.Data) > 0 {
+		return fmt.Sprintf("%d %s: %s", e.Status, e.Msg, e.Data)
+	}
+
+	return fmt.Sprintf("%d %s", e.Status, e.Msg)
+}
+
+func (e *ClientError) StatusCode() int {
+	return e.Status
+}
+
+func (e *ClientError) ErrorCode() string {
+	return e.Msg
+}
+
+func (e *ClientError) Details() interface{} {
+	if len(e.Data) == 0 {
+		return nil
+	}
+
+	return e.Data
+}
+
+func NewClient(baseURL string) *Client {
+	return &Client{strings.TrimSuffix(baseURL, "/"), http.DefaultClient, http.Header{}}
+}
+
+// ClientValues formats a field value for query strings, headers and path params. Zero values and nil pointers give
+// no value, a non nil pointer to a zero value gives one, and slices give one value per element.
+func ClientValues(v interface{}) []string {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return nil
+	}
+
+	explicit := false
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+
+		rv = rv.Elem()
+		explicit = true
+	}
+
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		var values []string
+		for i := 0; i < rv.Len(); i++ {
+			values = append(values, fmt.Sprint(rv.Index(i).Interface()))
+		}
+
+		return values
+	}
+
+	if !explicit && rv.IsZero() {
+		return nil
+	}
+
+	return []string{fmt.Sprint(rv.Interface())}
+}
+
+// ClientFiles returns the files of a *ClientFile or []*ClientFile field, nil files are left out.
+func ClientFiles(v interface{}) []*ClientFile {
+	var files []*ClientFile
+	switch v := v.(type) {
+	case *ClientFile:
+		files = []*ClientFile{v}
+	case []*ClientFile:
+		files = v
+	}
+
+	var present []*ClientFile
+	for _, f := range files {
+		if f != nil {
+			present = append(present, f)
+		}
+	}
+
+	return present
+}
+
+func writeClientMultipart(req *ClientRequest) (string, []byte, error) {
+	buf := &bytes.Buffer{}
+	writer := multipart.NewWriter(buf)
+	for k, values := range req.Form {
+		for _, v := range values {
+			err := writer.WriteField(k, v)
+			if err != nil {
+				return "", nil, err
+			}
+		}
+	}
+
+	for k, files := range req.Files {
+		for _, f := range files {
+			contentType := f.ContentType
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Disposition", mime.FormatMediaType("form-data",
+				map[string]string{"name": k, "filename": f.Filename}))
+			header.Set("Content-Type", contentType)
+			part, err := writer.CreatePart(header)
+			if err != nil {
+				return "", nil, err
+			}
+
+			if f.Content != nil {
+				_, err = io.Copy(part, f.Content)
+				if err != nil {
+					return "", nil, err
+				}
+			}
+		}
+	}
+
+	err := writer.Close()
+	return writer.FormDataContentType(), buf.Bytes(), err
+}
+
+// ClientPathValue formats a field value as an escaped path segment.
+func ClientPathValue(v interface{}) string {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+
+	values := ClientValues(v)
+	if values == nil && rv.IsValid() && rv.Kind() != reflect.Ptr && rv.Kind() != reflect.Slice {
+		// zero values are still part of the path.
+		values = []string{fmt.Sprint(rv.Interface())}
+	}
+
+	return url.PathEscape(strings.Join(values, ","))
+}
+
+// Do sends the request and decodes a successful response body into result, a failure response is returned as
+// *ClientError.
+func (c *Client) Do(ctx context.Context, req *ClientRequest, result interface{}) error {
+	target := c.BaseURL + req.Path
+	if len(req.Query) > 0 {
+		target += "?" + req.Query.Encode()
+	}
+
+	var body *bytes.Reader
+	contentType := ""
+	if req.Files != nil {
+		var data []byte
+		var err error
+		contentType, data, err = writeClientMultipart(req)
+		if err != nil {
+			return err
+		}
+
+		body = bytes.NewReader(data)
+	} else if req.Body != nil {
+		data, err := json.Marshal(req.Body)
+		if err != nil {
+			return err
+		}
+
+		contentType = "application/json"
+		body = bytes.NewReader(data)
+	} else {
+		body = bytes.NewReader(nil)
+	}
+
+	httpReq, err := http.NewRequest(req.Method, target, body)
+	if err != nil {
+		return err
+	}
+
+	httpReq = httpReq.WithContext(ctx)
+	for k, v := range c.Header {
+		httpReq.Header[k] = v
+	}
+
+	for k, v := range req.Header {
+		httpReq.Header[http.CanonicalHeaderKey(k)] = v
+	}
+
+	InjectTraceContext(ctx, httpReq.Header)
+	httpReq.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		httpReq.Header.Set("Content-Type", contentType)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 400 {
+		formatted := &struct {
+			Msg  string          `json:"msg"`
+			Data json.RawMessage `json:"data"`
+		}{}
+		if json.Unmarshal(data, formatted) != nil || formatted.Msg == "" {
+			return &ClientError{resp.StatusCode, strings.TrimSpace(string(data)), nil}
+		}
+
+		return &ClientError{resp.StatusCode, formatted.Msg, formatted.Data}
+	}
+
+	if result == nil || len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(data, result)
+}
@@ -0,0 +1,385 @@
+package kellyframework
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"go/format"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type clientGenerator struct {
+	frameworkPath string
+	imports       map[string]bool
+	typeNames     map[reflect.Type]string
+	usedNames     map[string]bool
+	pending       []reflect.Type
+	typeDefs      []string
+	methods       []string
+}
+
+// GenerateClient writes the Go source of a typed client for the routes, with one method per route. Named types
+// used by the arguments and the results are copied into the generated package, file fields become *ClientFile or
+// []*ClientFile fields. The body fields are sent as JSON, or as a multipart body when the argument has file fields.
+// Failure responses are returned as *ClientError.
+//
+// It is meant to be called by a 'go generate' program, see cmd/kellygen.
+func GenerateClient(w io.Writer, packageName string, clientName string, routes []*Route) error {
+	g := &clientGenerator{
+		frameworkImportPath(),
+		map[string]bool{"context": true},
+		make(map[reflect.Type]string),
+		map[string]bool{clientName: true, "New" + clientName: true},
+		nil,
+		nil,
+		nil,
+	}
+	g.imports[g.frameworkPath] = true
+
+	methodNames := make(map[string]bool)
+	for _, rt := range routes {
+		method, err := g.method(rt, methodNames)
+		if err != nil {
+			return fmt.Errorf("%s %s: %s", rt.Method, rt.Path, err)
+		}
+
+		g.methods = append(g.methods, method)
+	}
+
+	for len(g.pending) > 0 {
+		t := g.pending[0]
+		g.pending = g.pending[1:]
+		g.typeDefs = append(g.typeDefs, fmt.Sprintf("type %s %s\n", g.typeNames[t], g.underlyingExpr(t)))
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "// Code generated by kellygen. DO NOT EDIT.\n\npackage %s\n\nimport (\n", packageName)
+	var imports []string
+	for path := range g.imports {
+		imports = append(imports, path)
+	}
+
+	sort.Strings(imports)
+	for _, path := range imports {
+		fmt.Fprintf(buf, "\t%q\n", path)
+	}
+
+	fmt.Fprintf(buf, ")\n\n")
+	for _, def := range g.typeDefs {
+		fmt.Fprintf(buf, "%s\n", def)
+	}
+
+	frameworkName := g.packageName(g.frameworkPath)
+	fmt.Fprintf(buf, "type %s struct {\n*%s.Client\n}\n\n", clientName, frameworkName)
+	fmt.Fprintf(buf, "func New%s(baseURL string) *%s {\nreturn &%s{%s.NewClient(baseURL)}\n}\n\n", clientName,
+		clientName, clientName, frameworkName)
+	for _, method := range g.methods {
+		fmt.Fprintf(buf, "%s\n", strings.Replace(method, "CLIENT", clientName, 1))
+	}
+
+	source, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("generated source is invalid: %s\n%s", err, buf.Bytes())
+	}
+
+	_, err = w.Write(source)
+	return err
+}
+
+// frameworkImportPath is the import path of this package as the generated client should import it.
+func frameworkImportPath() string {
+	path := reflect.TypeOf(Client{}).PkgPath()
+	if i := strings.LastIndex(path, "/vendor/"); i >= 0 {
+		path = path[i+len("/vendor/"):]
+	}
+
+	return path
+}
+
+func exportedName(name string) string {
+	if name == "" {
+		return name
+	}
+
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+func (g *clientGenerator) packageName(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+func (g *clientGenerator) uniqueName(name string, used map[string]bool) string {
+	unique := name
+	for i := 2; used[unique]; i++ {
+		unique = name + strconv.Itoa(i)
+	}
+
+	used[unique] = true
+	return unique
+}
+
+// isStandardPackage reports whether the types of the package can be used by the generated client as they are.
+func isStandardPackage(path string) bool {
+	pkg, err := build.Import(path, "", build.FindOnly)
+	return err == nil && pkg.Goroot
+}
+
+// typeExpr is the Go expression of t in the generated package.
+func (g *clientGenerator) typeExpr(t reflect.Type) string {
+	if t == errorType {
+		return "error"
+	}
+
+	if t.Name() != "" {
+		if t.PkgPath() == "" {
+			return t.Name()
+		}
+
+		if isStandardPackage(t.PkgPath()) {
+			g.imports[t.PkgPath()] = true
+			return g.packageName(t.PkgPath()) + "." + t.Name()
+		}
+
+		name, ok := g.typeNames[t]
+		if !ok {
+			name = g.uniqueName(exportedName(t.Name()), g.usedNames)
+			g.typeNames[t] = name
+			g.pending = append(g.pending, t)
+		}
+
+		return name
+	}
+
+	return g.underlyingExpr(t)
+}
+
+func (g *clientGenerator) underlyingExpr(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return "*" + g.typeExpr(t.Elem())
+	case reflect.Slice:
+		return "[]" + g.typeExpr(t.Elem())
+	case reflect.Array:
+		return fmt.Sprintf("[%d]%s", t.Len(), g.typeExpr(t.Elem()))
+	case reflect.Map:
+		return fmt.Sprintf("map[%s]%s", g.typeExpr(t.Key()), g.typeExpr(t.Elem()))
+	case reflect.Struct:
+		return g.structExpr(t)
+	case reflect.Interface, reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return "interface{}"
+	}
+
+	// the basic kinds of named types, e.g. 'type Status string'.
+	return t.Kind().String()
+}
+
+func (g *clientGenerator) structExpr(t reflect.Type) string {
+	buf := &bytes.Buffer{}
+	buf.WriteString("struct {\n")
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := ""
+		if field.Tag != "" {
+			tag = " `" + string(field.Tag) + "`"
+		}
+
+		if field.Anonymous && (field.Type.Kind() == reflect.Struct ||
+			(field.Type.Kind() == reflect.Ptr && field.Type.Elem().Kind() == reflect.Struct)) {
+			fmt.Fprintf(buf, "%s%s\n", g.typeExpr(field.Type), tag)
+			continue
+		}
+
+		if field.PkgPath != "" {
+			continue
+		}
+
+		fmt.Fprintf(buf, "%s %s%s\n", field.Name, g.fieldTypeExpr(field.Type), tag)
+	}
+
+	buf.WriteString("}")
+	return buf.String()
+}
+
+// fieldTypeExpr is typeExpr with the file types replaced by the ones the client sends.
+func (g *clientGenerator) fieldTypeExpr(t reflect.Type) string {
+	if !isFileType(t) {
+		return g.typeExpr(t)
+	}
+
+	fileExpr := "*" + g.packageName(g.frameworkPath) + ".ClientFile"
+	if t.Kind() == reflect.Slice {
+		return "[]" + fileExpr
+	}
+
+	return fileExpr
+}
+
+// bodyExpr is a struct literal holding the JSON body fields of the argument, the fields sent in other places are
+// left out, so that they do not set the fields twice.
+func (g *clientGenerator) bodyExpr(fields []reflect.StructField) string {
+	buf := &bytes.Buffer{}
+	buf.WriteString("&struct {\n")
+	for _, field := range fields {
+		tag := ""
+		if jsonTag, ok := field.Tag.Lookup("json"); ok {
+			tag = fmt.Sprintf(" `json:%s`", strconv.Quote(jsonTag))
+		}
+
+		fmt.Fprintf(buf, "%s %s%s\n", field.Name, g.typeExpr(field.Type), tag)
+	}
+
+	buf.WriteString("}{")
+	for i, field := range fields {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+
+		fmt.Fprintf(buf, "arg.%s", field.Name)
+	}
+
+	buf.WriteString("}")
+	return buf.String()
+}
+
+// resultType is the type decoded from successful responses, nil if the route has no result.
+func resultType(methodType reflect.Type, bypassResponseBody bool) reflect.Type {
+	t := methodType.Out(0)
+	if bypassResponseBody || t == errorType || t == formattedResponseType {
+		return nil
+	}
+
+	return t
+}
+
+func (g *clientGenerator) pathExpr(rt *Route, binding *argumentBinding) (string, map[string]bool, error) {
+	used := make(map[string]bool)
+	var parts []string
+	last := 0
+	for _, loc := range httprouterParamPattern.FindAllStringSubmatchIndex(rt.Path, -1) {
+		parts = append(parts, strconv.Quote(rt.Path[last:loc[0]]))
+		param := rt.Path[loc[2]:loc[3]]
+		fieldName := ""
+		for _, f := range binding.fields {
+			if f.source == SourcePath && strings.EqualFold(f.name, param) {
+				fieldName = f.field.Name
+			}
+		}
+
+		for _, field := range binding.settable {
+			if fieldName == "" && strings.EqualFold(formFieldName(field), param) {
+				fieldName = field.Name
+			}
+		}
+
+		if fieldName == "" {
+			return "", nil, fmt.Errorf("no argument field for the path param %s", param)
+		}
+
+		used[fieldName] = true
+		parts = append(parts, fmt.Sprintf("%s.ClientPathValue(arg.%s)", g.packageName(g.frameworkPath), fieldName))
+		last = loc[1]
+	}
+
+	if last < len(rt.Path) || len(parts) == 0 {
+		parts = append(parts, strconv.Quote(rt.Path[last:]))
+	}
+
+	return strings.Join(parts, " + "), used, nil
+}
+
+func (g *clientGenerator) method(rt *Route, methodNames map[string]bool) (string, error) {
+	methodType := reflect.TypeOf(rt.Function)
+	err := checkServiceMethodPrototype(methodType)
+	if err != nil {
+		return "", err
+	}
+
+	binding, err := newArgumentBinding(methodType.In(1).Elem())
+	if err != nil {
+		return "", err
+	}
+
+	path, pathFields, err := g.pathExpr(rt, binding)
+	if err != nil {
+		return "", err
+	}
+
+	fw := g.packageName(g.frameworkPath)
+	g.imports["net/http"] = true
+	g.imports["net/url"] = true
+	name := g.uniqueName(exportedName(functionName(rt.Function)), methodNames)
+	argExpr := g.typeExpr(methodType.In(1))
+	result := resultType(methodType, rt.BypassResponseBody)
+
+	buf := &bytes.Buffer{}
+	if result != nil {
+		fmt.Fprintf(buf, "func (c *CLIENT) %s(ctx context.Context, arg %s) (%s, error) {\n", name, argExpr,
+			g.typeExpr(result))
+	} else {
+		fmt.Fprintf(buf, "func (c *CLIENT) %s(ctx context.Context, arg %s) error {\n", name, argExpr)
+	}
+
+	fmt.Fprintf(buf, "req := &%s.ClientRequest{Method: %q, Path: %s, Query: url.Values{}, Header: http.Header{}}\n",
+		fw, strings.ToUpper(rt.Method), path)
+	for _, f := range binding.fields {
+		switch f.source {
+		case SourceQuery:
+			fmt.Fprintf(buf, "req.Query[%q] = %s.ClientValues(arg.%s)\n", f.name, fw, f.field.Name)
+		case SourceHeader:
+			fmt.Fprintf(buf, "req.Header[%q] = %s.ClientValues(arg.%s)\n", http.CanonicalHeaderKey(f.name), fw, f.field.Name)
+		case SourceCookie:
+			fmt.Fprintf(buf, "for _, v := range %s.ClientValues(arg.%s) {\n", fw, f.field.Name)
+			fmt.Fprintf(buf, "req.Header.Add(\"Cookie\", (&http.Cookie{Name: %q, Value: v}).String())\n}\n", f.name)
+		}
+	}
+
+	if requestHasBody(rt) {
+		var bodyFields []reflect.StructField
+		multipart := false
+		for _, field := range binding.settable {
+			if pathFields[field.Name] && !isBodyBound(field) {
+				continue
+			}
+
+			bodyFields = append(bodyFields, field)
+			multipart = multipart || isFileType(field.Type)
+		}
+
+		if multipart {
+			fmt.Fprintf(buf, "req.Form = url.Values{}\nreq.Files = map[string][]*%s.ClientFile{}\n", fw)
+			for _, field := range bodyFields {
+				if isFileType(field.Type) {
+					fmt.Fprintf(buf, "req.Files[%q] = %s.ClientFiles(arg.%s)\n", formFieldName(field), fw, field.Name)
+				} else {
+					fmt.Fprintf(buf, "req.Form[%q] = %s.ClientValues(arg.%s)\n", formFieldName(field), fw, field.Name)
+				}
+			}
+		} else {
+			fmt.Fprintf(buf, "req.Body = %s\n", g.bodyExpr(bodyFields))
+		}
+	} else {
+		for _, field := range binding.settable {
+			if pathFields[field.Name] || isFileType(field.Type) || isBodyBound(field) {
+				continue
+			}
+
+			fmt.Fprintf(buf, "req.Query[%q] = %s.ClientValues(arg.%s)\n", formFieldName(field), fw, field.Name)
+		}
+	}
+
+	if result != nil {
+		fmt.Fprintf(buf, "var result %s\nerr := c.Do(ctx, req, &result)\nreturn result, err\n}\n", g.typeExpr(result))
+	} else {
+		fmt.Fprintf(buf, "return c.Do(ctx, req, nil)\n}\n")
+	}
+
+	return buf.String(), nil
+}
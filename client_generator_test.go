@@ -0,0 +1,138 @@
+package kellyframework
+
+import (
+	"bytes"
+	"context"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGenerateClient(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := GenerateClient(buf, "usersclient", "Users", []*Route{
+		{Method: "POST", Path: "/user/:Name", Function: openAPIAddUser},
+		{Method: "GET", Path: "/user/:Name", Function: openAPIGetUser},
+		{Method: "GET", Path: "/users/", Function: openAPIGetUser},
+		{Method: "POST", Path: "/upload", Function: uploadFunction},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := buf.String()
+	if _, err := parser.ParseFile(token.NewFileSet(), "client.go", source, 0); err != nil {
+		t.Fatal("generated source does not parse:", err, source)
+	}
+
+	for _, expected := range []string{
+		"package usersclient",
+		strconv.Quote(frameworkImportPath()),
+		"type OpenAPIUser struct {\n\tOpenAPIUserName\n\tOpenAPIUserInfo\n",
+		"`kelly:\"header=X-Request-Id\"`",
+		"Age     int    `json:\"age\" validate:\"min=0,max=140\"`",
+		"func (c *Users) OpenAPIAddUser(ctx context.Context, arg *OpenAPIUser) error {",
+		"Path: \"/user/\" + kellyframework.ClientPathValue(arg.Name)",
+		"req.Header[\"X-Request-Id\"] = kellyframework.ClientValues(arg.RequestID)",
+		"req.Body = &struct {\n\t\tAge     int    `json:\"age\"`\n\t\tAddress string `json:\"address,omitempty\"`\n" +
+			"\t\tFriends []*OpenAPIUser\n\t}{arg.Age, arg.Address, arg.Friends}",
+		"Avatar *kellyframework.ClientFile   `validate:\"required\"`",
+		"req.Form[\"Title\"] = kellyframework.ClientValues(arg.Title)",
+		"req.Files[\"photo\"] = kellyframework.ClientFiles(arg.Photos)",
+		"func (c *Users) OpenAPIGetUser(ctx context.Context, arg *OpenAPIUserName) (*OpenAPIUserInfo, error) {",
+		"func (c *Users) OpenAPIGetUser2(ctx context.Context, arg *OpenAPIUserName) (*OpenAPIUserInfo, error) {",
+		"req.Query[\"Name\"] = kellyframework.ClientValues(arg.Name)",
+	} {
+		if !strings.Contains(source, expected) {
+			t.Error("generated source does not contain", expected, "\n", source)
+		}
+	}
+}
+
+type clientArgument struct {
+	ID    int    `schema:"id" validate:"min=1"`
+	Token string `kelly:"header=X-Token"`
+	Tags  []string
+}
+
+type clientResult struct {
+	ID    int
+	Token string
+	Tags  []string
+}
+
+func clientFunction(ctx *ServiceMethodContext, arg *clientArgument) (*clientResult, error) {
+	if arg.ID == 2 {
+		return nil, NewServiceMethodError(http.StatusNotFound, "no such item", map[string]int{"id": arg.ID})
+	}
+
+	return &clientResult{arg.ID, arg.Token, arg.Tags}, nil
+}
+
+func TestClient(t *testing.T) {
+	router, err := NewHTTPRouter([]*Route{{Method: "GET", Path: "/items/:id", Function: clientFunction}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	client := NewClient(server.URL + "/")
+	call := func(id int) (*clientResult, error) {
+		req := &ClientRequest{Method: "GET", Path: "/items/" + ClientPathValue(id), Query: url.Values{},
+			Header: http.Header{"X-Token": ClientValues("secret")}}
+		req.Query["Tags"] = ClientValues([]string{"a", "b"})
+		var result *clientResult
+		err := client.Do(context.Background(), req, &result)
+		return result, err
+	}
+
+	result, err := call(1)
+	if err != nil || result.ID != 1 || result.Token != "secret" || strings.Join(result.Tags, ",") != "a,b" {
+		t.Error("result is wrong:", result, err)
+	}
+
+	_, err = call(2)
+	clientErr, ok := err.(*ClientError)
+	if !ok || clientErr.Status != http.StatusNotFound || clientErr.Msg != "no such item" ||
+		string(clientErr.Data) != `{"id":2}` {
+		t.Error("error is wrong:", err)
+	}
+
+	_, err = call(0)
+	clientErr, ok = err.(*ClientError)
+	if !ok || clientErr.Status != http.StatusBadRequest {
+		t.Error("validation error is wrong:", err)
+	}
+
+	if ClientValues(0) != nil || len(ClientValues(new(int))) != 1 || ClientValues((*string)(nil)) != nil {
+		t.Error("client values are wrong")
+	}
+}
+
+func TestClientMultipart(t *testing.T) {
+	router, err := NewHTTPRouter([]*Route{{Method: "POST", Path: "/upload", Function: uploadFunction}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	req := &ClientRequest{Method: "POST", Path: "/upload", Form: url.Values{"Title": ClientValues("t")},
+		Files: map[string][]*ClientFile{
+			"Avatar": ClientFiles(&ClientFile{Filename: "face.txt", Content: strings.NewReader("face")}),
+			"photo":  ClientFiles([]*ClientFile{{Filename: "a.txt"}, nil, {Filename: "b.txt"}}),
+		}}
+	var result map[string]interface{}
+	err = NewClient(server.URL).Do(context.Background(), req, &result)
+	if err != nil || result["title"] != "t" || result["avatar"] != "face" || result["photos"] != float64(2) {
+		t.Error("result is wrong:", result, err)
+	}
+}
@@ -0,0 +1,114 @@
+// Command kellygen generates a typed Go client for the routes of a kellyframework service.
+//
+// The routes are given as a package level variable of type []*kellyframework.Route, or a function returning one:
+//
+//	//go:generate kellygen -routes github.com/me/users/service.Routes -package usersclient -client Users -o client.go
+//
+// kellygen builds a small program importing the routes package and runs it with 'go run', so it has to be run
+// where 'go build' can resolve that package.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const frameworkPath = "github.com/abadcafe/kellyframework"
+
+var generatorTemplate = template.Must(template.New("generator").Parse(`package main
+
+import (
+	"os"
+
+	framework "{{.FrameworkPath}}"
+	routes "{{.RoutesPath}}"
+)
+
+func main() {
+	var all []*framework.Route
+	{{if .Func}}all = routes.{{.RoutesName}}(){{else}}all = routes.{{.RoutesName}}{{end}}
+	err := framework.GenerateClient(os.Stdout, {{printf "%q" .Package}}, {{printf "%q" .Client}}, all)
+	if err != nil {
+		os.Stderr.WriteString(err.Error() + "\n")
+		os.Exit(1)
+	}
+}
+`))
+
+type generatorParams struct {
+	FrameworkPath string
+	RoutesPath    string
+	RoutesName    string
+	Func          bool
+	Package       string
+	Client        string
+}
+
+func main() {
+	routes := flag.String("routes", "", "the routes as 'import/path.Name', a []*Route variable or a func() []*Route")
+	isFunc := flag.Bool("func", false, "the routes are returned by a function")
+	pkg := flag.String("package", "", "the package name of the generated client, defaults to $GOPACKAGE")
+	client := flag.String("client", "Client", "the type name of the generated client")
+	output := flag.String("o", "", "the output file, defaults to stdout")
+	flag.Parse()
+
+	i := strings.LastIndex(*routes, ".")
+	if i <= 0 || i < strings.LastIndex(*routes, "/") {
+		log.Fatalf("invalid -routes %q, it should be 'import/path.Name'", *routes)
+	}
+
+	if *pkg == "" {
+		*pkg = os.Getenv("GOPACKAGE")
+	}
+
+	if *pkg == "" {
+		log.Fatal("-package is required outside 'go generate'")
+	}
+
+	err := run(&generatorParams{frameworkPath, (*routes)[:i], (*routes)[i+1:], *isFunc, *pkg, *client}, *output)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run generates the client, it returns instead of exiting so the generator program is always removed.
+func run(params *generatorParams, output string) error {
+	// the program is put under the working directory so that it resolves packages as the caller does.
+	dir, err := ioutil.TempDir(".", "kellygen")
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(dir)
+	f, err := os.Create(filepath.Join(dir, "main.go"))
+	if err != nil {
+		return err
+	}
+
+	err = generatorTemplate.Execute(f, params)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("go", "run", "./"+filepath.Base(dir))
+	cmd.Stderr = os.Stderr
+	source, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("generating the client failed: %s", err)
+	}
+
+	if output == "" {
+		_, err = os.Stdout.Write(source)
+		return err
+	}
+
+	return ioutil.WriteFile(output, source, 0644)
+}
@@ -0,0 +1,291 @@
+package kellyframework
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RequestIDHeader is the request header the request ID of error reports is taken from.
+const RequestIDHeader = "X-Request-Id"
+
+// reportRedactedHeaders are left out of error reports, they carry credentials.
+var reportRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// ErrorReport describes a service method which returned an error or panicked. URI is the escaped path of the request,
+// the query is left out as it may carry personal data.
+type ErrorReport struct {
+	Time       time.Time   `json:"time"`
+	RequestID  string      `json:"requestId,omitempty"`
+	Method     string      `json:"method"`
+	Pattern    string      `json:"pattern"`
+	URI        string      `json:"uri"`
+	RemoteAddr string      `json:"remoteAddr"`
+	Header     http.Header `json:"header,omitempty"`
+	// Argument is the decoded argument, after the interceptors.
+	Argument interface{} `json:"argument"`
+	Status   int         `json:"status"`
+	Error    string      `json:"error"`
+	// Err is the error returned by the service method, nil for panics and *FormattedResponse failures.
+	Err        error  `json:"-"`
+	Panic      string `json:"panic,omitempty"`
+	Stack      string `json:"stack,omitempty"`
+	IncidentID string `json:"incidentId,omitempty"`
+}
+
+// ErrorReporter is notified after the response of a failed service method call is written. It is called on the
+// request goroutine, so a slow backend should queue the reports, as HTTPErrorReporter does.
+type ErrorReporter interface {
+	Report(report *ErrorReport)
+}
+
+// ErrorReporterFunc adapts a function to ErrorReporter.
+type ErrorReporterFunc func(report *ErrorReport)
+
+func (f ErrorReporterFunc) Report(report *ErrorReport) {
+	f(report)
+}
+
+// WithErrorReporter appends error reporters to the handler.
+func WithErrorReporter(reporters ...ErrorReporter) HandlerOption {
+	return func(h *ServiceHandler) {
+		h.errorReporters = append(append([]ErrorReporter{}, h.errorReporters...), reporters...)
+	}
+}
+
+func (h *ServiceHandler) reportFailure(r *http.Request, inv *Invocation, f *Failure, ps *panicStack) {
+	header := http.Header{}
+	for k, v := range r.Header {
+		header[k] = v
+	}
+
+	for _, k := range reportRedactedHeaders {
+		header.Del(k)
+	}
+
+	route := h.routeOf(r)
+	report := &ErrorReport{
+		Time:       time.Now(),
+		RequestID:  r.Header.Get(RequestIDHeader),
+		Method:     r.Method,
+		Pattern:    route.Path,
+		URI:        r.URL.EscapedPath(),
+		RemoteAddr: r.RemoteAddr,
+		Header:     header,
+		Argument:   inv.Argument,
+		Status:     f.Status,
+		Error:      f.Msg,
+		Err:        f.Err,
+	}
+
+	if ps != nil {
+		report.Error = ps.Error()
+		report.Panic = ps.Panic
+		report.Stack = ps.Stack
+		if incident, ok := f.Data.(*PanicIncident); ok {
+			report.IncidentID = incident.IncidentID
+		}
+	} else if f.Err != nil {
+		report.Error = f.Err.Error()
+	}
+
+	for _, reporter := range h.errorReporters {
+		reporter.Report(report)
+	}
+}
+
+// JSONLinesErrorReporter writes every report as a line of JSON.
+type JSONLinesErrorReporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewJSONLinesErrorReporter(w io.Writer) *JSONLinesErrorReporter {
+	return &JSONLinesErrorReporter{w: w}
+}
+
+func (reporter *JSONLinesErrorReporter) Report(report *ErrorReport) {
+	line, err := json.Marshal(report)
+	if err != nil {
+		// the argument could not be encoded, keep the rest of the report.
+		withoutArgument := *report
+		withoutArgument.Argument = nil
+		line, _ = json.Marshal(&withoutArgument)
+	}
+
+	reporter.mu.Lock()
+	defer reporter.mu.Unlock()
+	reporter.w.Write(append(line, '\n'))
+}
+
+const httpErrorReporterQueueSize = 256
+
+// HTTPErrorReporter posts every report to an error tracking service. Backends with their own payload format, e.g.
+// Sentry, are supported by setting Encode.
+//
+// Reports are posted by a background goroutine, so requests never wait on the backend. When the backend falls
+// behind and the queue is full, reports are dropped and logged to ErrorLog. The argument is JSON encoded when the
+// report is queued, so Encode sees it as a json.RawMessage. Call Close before the process exits to post the queued
+// reports.
+type HTTPErrorReporter struct {
+	URL    string
+	Client *http.Client
+	// Header is added to every request, e.g. the authentication header of the backend.
+	Header http.Header
+	// Encode builds the request body, the report is JSON encoded when it is nil.
+	Encode func(report *ErrorReport) ([]byte, error)
+	// ErrorLog receives the failures of posting reports.
+	ErrorLog  *log.Logger
+	once      sync.Once
+	closeOnce sync.Once
+	queue     chan *ErrorReport
+	flush     chan chan struct{}
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+func NewHTTPErrorReporter(url string) *HTTPErrorReporter {
+	return &HTTPErrorReporter{URL: url, Client: &http.Client{Timeout: 5 * time.Second}, Header: http.Header{}}
+}
+
+func (reporter *HTTPErrorReporter) Report(report *ErrorReport) {
+	reporter.once.Do(reporter.start)
+	select {
+	case <-reporter.stop:
+		reporter.logf("report error to %s dropped: the reporter is closed", reporter.URL)
+		return
+	default:
+	}
+
+	// the argument may still be changed by the request goroutine, the queued report keeps its encoding.
+	snapshot := *report
+	snapshot.Argument = nil
+	if argument, err := json.Marshal(report.Argument); err == nil {
+		snapshot.Argument = json.RawMessage(argument)
+	}
+
+	select {
+	case reporter.queue <- &snapshot:
+	default:
+		reporter.logf("report error to %s dropped: queue is full", reporter.URL)
+	}
+}
+
+// Flush posts the queued reports and waits for the posts to finish.
+func (reporter *HTTPErrorReporter) Flush() {
+	reporter.once.Do(reporter.start)
+	done := make(chan struct{})
+	select {
+	case reporter.flush <- done:
+		<-done
+	case <-reporter.stopped:
+	}
+}
+
+// Close posts the queued reports and stops the background goroutine, the reports made later are dropped.
+func (reporter *HTTPErrorReporter) Close() error {
+	reporter.once.Do(reporter.start)
+	reporter.closeOnce.Do(func() {
+		close(reporter.stop)
+	})
+
+	<-reporter.stopped
+	return nil
+}
+
+func (reporter *HTTPErrorReporter) start() {
+	reporter.queue = make(chan *ErrorReport, httpErrorReporterQueueSize)
+	reporter.flush = make(chan chan struct{})
+	reporter.stop = make(chan struct{})
+	reporter.stopped = make(chan struct{})
+	go reporter.run()
+}
+
+func (reporter *HTTPErrorReporter) run() {
+	for {
+		select {
+		case report := <-reporter.queue:
+			reporter.send(report)
+		case done := <-reporter.flush:
+			reporter.drain()
+			close(done)
+		case <-reporter.stop:
+			reporter.drain()
+			close(reporter.stopped)
+			return
+		}
+	}
+}
+
+// drain posts the queued reports without waiting for more.
+func (reporter *HTTPErrorReporter) drain() {
+	for {
+		select {
+		case report := <-reporter.queue:
+			reporter.send(report)
+		default:
+			return
+		}
+	}
+}
+
+func (reporter *HTTPErrorReporter) send(report *ErrorReport) {
+	err := reporter.post(report)
+	if err != nil {
+		reporter.logf("report error to %s failed: %s", reporter.URL, err)
+	}
+}
+
+func (reporter *HTTPErrorReporter) logf(format string, v ...interface{}) {
+	if reporter.ErrorLog != nil {
+		reporter.ErrorLog.Printf(format, v...)
+	}
+}
+
+func (reporter *HTTPErrorReporter) post(report *ErrorReport) error {
+	encode := reporter.Encode
+	if encode == nil {
+		encode = func(report *ErrorReport) ([]byte, error) {
+			return json.Marshal(report)
+		}
+	}
+
+	body, err := encode(report)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", reporter.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	for k, v := range reporter.Header {
+		req.Header[k] = v
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	client := reporter.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
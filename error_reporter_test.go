@@ -0,0 +1,111 @@
+package kellyframework
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func reportedFunction(ctx *ServiceMethodContext, arg *struct{ Name string }) (string, error) {
+	switch arg.Name {
+	case "panic":
+		panic("boom")
+	case "error":
+		return "", NewServiceMethodError(http.StatusConflict, "conflict", nil)
+	}
+
+	return "ok", nil
+}
+
+func TestErrorReporter(t *testing.T) {
+	var posted []map[string]interface{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		event := map[string]interface{}{}
+		if err := json.Unmarshal(body, &event); err != nil || r.Header.Get("X-Sentry-Auth") != "key" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		posted = append(posted, event)
+	}))
+	defer backend.Close()
+
+	httpReporter := NewHTTPErrorReporter(backend.URL)
+	httpReporter.Header.Set("X-Sentry-Auth", "key")
+	httpReporter.Encode = func(report *ErrorReport) ([]byte, error) {
+		return json.Marshal(map[string]interface{}{"message": report.Error, "transaction": report.Pattern})
+	}
+
+	lines := &bytes.Buffer{}
+	router, err := NewHTTPRouter([]*Route{{Method: "GET", Path: "/hello/:Name", Function: reportedFunction}},
+		WithErrorReporter(NewJSONLinesErrorReporter(lines), httpReporter))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"kelly", "error", "panic"} {
+		req := httptest.NewRequest("GET", "/hello/"+name+"?token=secret", nil)
+		req.Header.Set(RequestIDHeader, "request-"+name)
+		req.Header.Set("Authorization", "secret")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	httpReporter.Flush()
+	var reports []*ErrorReport
+	for _, line := range strings.Split(strings.TrimSpace(lines.String()), "\n") {
+		report := &ErrorReport{}
+		if err := json.Unmarshal([]byte(line), report); err != nil {
+			t.Fatal("report line is wrong:", line)
+		}
+
+		reports = append(reports, report)
+	}
+
+	if len(reports) != 2 || strings.Contains(lines.String(), "secret") {
+		t.Fatal("reports are wrong:", lines)
+	}
+
+	if reports[0].RequestID != "request-error" || reports[0].Pattern != "/hello/:Name" ||
+		reports[0].URI != "/hello/error" || reports[0].Status != http.StatusConflict || reports[0].Error != "409 conflict" ||
+		reports[0].Argument.(map[string]interface{})["Name"] != "error" {
+		t.Error("error report is wrong:", lines)
+	}
+
+	if reports[1].Panic != "boom" || !strings.Contains(reports[1].Stack, "goroutine") ||
+		reports[1].IncidentID == "" || reports[1].Status != http.StatusInternalServerError {
+		t.Error("panic report is wrong:", lines)
+	}
+
+	if len(posted) != 2 || posted[0]["message"] != "409 conflict" || posted[1]["transaction"] != "/hello/:Name" {
+		t.Error("posted reports are wrong:", posted)
+	}
+}
+
+func TestHTTPErrorReporterClose(t *testing.T) {
+	var posted []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		posted = append(posted, string(body))
+	}))
+	defer backend.Close()
+
+	reporter := NewHTTPErrorReporter(backend.URL)
+	reporter.Encode = func(report *ErrorReport) ([]byte, error) {
+		return report.Argument.(json.RawMessage), nil
+	}
+
+	argument := map[string]string{"Name": "kelly"}
+	reporter.Report(&ErrorReport{Argument: argument})
+	argument["Name"] = "changed"
+	reporter.Close()
+	reporter.Report(&ErrorReport{Argument: argument})
+	reporter.Close()
+	if len(posted) != 1 || posted[0] != `{"Name":"kelly"}` {
+		t.Error("posted reports are wrong:", posted)
+	}
+}
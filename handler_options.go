@@ -0,0 +1,45 @@
+package kellyframework
+
+import (
+	"time"
+)
+
+// HandlerOption customizes a ServiceHandler. Options passed to the router registration functions are applied to
+// every handler before the options of each Route, so a route can override the router wide settings.
+type HandlerOption func(*ServiceHandler)
+
+// WithLoggerContextKey sets the request context key of the MethodCallLogger the handler records method calls to.
+func WithLoggerContextKey(key interface{}) HandlerOption {
+	return func(h *ServiceHandler) {
+		h.loggerContextKey = key
+	}
+}
+
+// WithBypassRequestBody leaves the request body to the service method instead of decoding it into the argument.
+func WithBypassRequestBody(bypass bool) HandlerOption {
+	return func(h *ServiceHandler) {
+		h.bypassRequestBody = bypass
+	}
+}
+
+// WithBypassResponseBody leaves the response body to the service method, only errors are still written.
+func WithBypassResponseBody(bypass bool) HandlerOption {
+	return func(h *ServiceHandler) {
+		h.bypassResponseBody = bypass
+	}
+}
+
+// WithResponseRenderer sets the renderer shaping the results and failures, FormattedResponseRenderer by default.
+func WithResponseRenderer(renderer ResponseRenderer) HandlerOption {
+	return func(h *ServiceHandler) {
+		h.renderer = renderer
+	}
+}
+
+// WithTimeout sets a deadline on ServiceMethodContext.Context. A method returning an error which wraps
+// context.DeadlineExceeded gets a 504 response.
+func WithTimeout(timeout time.Duration) HandlerOption {
+	return func(h *ServiceHandler) {
+		h.timeout = timeout
+	}
+}
@@ -0,0 +1,72 @@
+package kellyframework
+
+import (
+	"reflect"
+)
+
+// Invocation is one call of a service method as seen by interceptors.
+type Invocation struct {
+	*ServiceMethodContext
+	Route *Route
+	// Argument is the decoded and validated argument, a pointer to the argument struct of the method. It is also
+	// what the method call log records, so an interceptor may replace it with a redacted copy after calling next.
+	Argument interface{}
+}
+
+// Invoker calls the service method, or the interceptors after the current one. The result is the first return value
+// of the method, the error is its error return value, or the first return value when it is an error.
+type Invoker func(inv *Invocation) (interface{}, error)
+
+// Interceptor runs around the service method call. It can inspect the argument, skip the call by not calling next,
+// and replace the result or the error. A panic of the method reaches the interceptors as an error.
+type Interceptor func(inv *Invocation, next Invoker) (interface{}, error)
+
+// WithInterceptor appends interceptors to the handler. The first interceptor is the outermost one, interceptors run
+// after the middleware and the argument parsing, and before the response is rendered.
+func WithInterceptor(interceptors ...Interceptor) HandlerOption {
+	return func(h *ServiceHandler) {
+		h.interceptors = append(append([]Interceptor{}, h.interceptors...), interceptors...)
+	}
+}
+
+func (h *ServiceHandler) invokeServiceMethod(inv *Invocation) (interface{}, error) {
+	if h.method.call != nil {
+		return doTypedServiceMethodCall(h.method, inv.ServiceMethodContext, inv.Argument)
+	}
+
+	out, methodPanic := doServiceMethodCall(h.method, []reflect.Value{
+		reflect.ValueOf(inv.ServiceMethodContext),
+		reflect.ValueOf(inv.Argument),
+	})
+	if methodPanic != nil {
+		return nil, methodPanic
+	}
+
+	if len(out) == 2 && !out[1].IsNil() {
+		return out[0].Interface(), out[1].Interface().(error)
+	}
+
+	if err, ok := out[0].Interface().(error); ok && len(out) == 1 {
+		return nil, err
+	}
+
+	return out[0].Interface(), nil
+}
+
+func (h *ServiceHandler) invoke(inv *Invocation) (result interface{}, err error) {
+	defer func() {
+		if panicInfo := recover(); panicInfo != nil {
+			result, err = nil, newPanicStack(panicInfo)
+		}
+	}()
+
+	invoker := h.invokeServiceMethod
+	for i := len(h.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := h.interceptors[i], invoker
+		invoker = func(inv *Invocation) (interface{}, error) {
+			return interceptor(inv, next)
+		}
+	}
+
+	return invoker(inv)
+}
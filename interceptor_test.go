@@ -0,0 +1,115 @@
+package kellyframework
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type interceptorArgument struct {
+	Name     string
+	Password string
+}
+
+func interceptorFunction(ctx *ServiceMethodContext, arg *interceptorArgument) (string, error) {
+	if arg.Name == "panic" {
+		panic("boom")
+	}
+
+	return "hello " + arg.Name, nil
+}
+
+type recordingLogger map[string]string
+
+func (l recordingLogger) Record(field string, value string) {
+	l[field] = value
+}
+
+type recordingLoggerKey struct{}
+
+func TestInterceptor(t *testing.T) {
+	var trail []string
+	audit := func(inv *Invocation, next Invoker) (interface{}, error) {
+		trail = append(trail, inv.Route.Path+" "+inv.Argument.(*interceptorArgument).Name)
+		result, err := next(inv)
+		redacted := *inv.Argument.(*interceptorArgument)
+		redacted.Password = "***"
+		inv.Argument = &redacted
+		return result, err
+	}
+	deny := func(inv *Invocation, next Invoker) (interface{}, error) {
+		if inv.Argument.(*interceptorArgument).Name == "root" {
+			return nil, NewServiceMethodError(http.StatusForbidden, "forbidden", nil)
+		}
+
+		return next(inv)
+	}
+	recoverPanic := func(inv *Invocation, next Invoker) (interface{}, error) {
+		result, err := next(inv)
+		if err != nil && strings.Contains(err.Error(), "boom") {
+			return "recovered", nil
+		}
+
+		return strings.ToUpper(result.(string)), err
+	}
+
+	router, err := NewHTTPRouter([]*Route{{Method: "POST", Path: "/hello", Function: interceptorFunction,
+		Options: []HandlerOption{WithInterceptor(deny, recoverPanic)}}},
+		WithInterceptor(audit), WithLoggerContextKey(recordingLoggerKey{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(body string) (*httptest.ResponseRecorder, recordingLogger) {
+		logger := recordingLogger{}
+		req := httptest.NewRequest("POST", "/hello", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req = req.WithContext(context.WithValue(req.Context(), recordingLoggerKey{}, MethodCallLogger(logger)))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder, logger
+	}
+
+	recorder, logger := serve(`{"Name":"kelly","Password":"secret"}`)
+	if !strings.Contains(recorder.Body.String(), `"HELLO KELLY"`) {
+		t.Error("result is not replaced:", recorder.Body)
+	}
+
+	if strings.Contains(logger["methodCallArgument"], "secret") {
+		t.Error("argument is not redacted:", logger["methodCallArgument"])
+	}
+
+	recorder, _ = serve(`{"Name":"root"}`)
+	if recorder.Code != http.StatusForbidden {
+		t.Error("authorization is wrong:", recorder.Code, recorder.Body)
+	}
+
+	recorder, _ = serve(`{"Name":"panic"}`)
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"recovered"`) {
+		t.Error("panic is not replaced:", recorder.Code, recorder.Body)
+	}
+
+	if strings.Join(trail, ",") != "/hello kelly,/hello root,/hello panic" {
+		t.Error("interceptor order is wrong:", trail)
+	}
+}
+
+func TestInterceptorPanic(t *testing.T) {
+	handler, err := NewServiceHandlerWithOptions(interceptorFunction, WithPanicPolicy(PanicPolicy{Debug: true}),
+		WithInterceptor(func(inv *Invocation, next Invoker) (interface{}, error) {
+			panic(errors.New("interceptor failed"))
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTPWithParams(recorder, httptest.NewRequest("GET", "/hello?Name=kelly", nil), nil)
+	if recorder.Code != http.StatusInternalServerError ||
+		!strings.Contains(recorder.Body.String(), "interceptor failed") {
+		t.Error("interceptor panic is wrong:", recorder.Code, recorder.Body)
+	}
+}
@@ -0,0 +1,312 @@
+package kellyframework
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	parsePhase  = "parse"
+	callPhase   = "call"
+	encodePhase = "encode"
+)
+
+// DefaultMetricsBuckets are the upper bounds in seconds of the phase duration histograms, the same as the default
+// buckets of the Prometheus clients.
+var DefaultMetricsBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metricsRoute struct {
+	method  string
+	pattern string
+}
+
+type metricsRequest struct {
+	metricsRoute
+	status int
+}
+
+type metricsPhase struct {
+	metricsRoute
+	phase string
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Metrics counts the requests of the handlers it is given to with WithMetrics, and serves them in the Prometheus
+// text exposition format. Mount it on any path of the router:
+//
+//	router.Handler("GET", "/metrics", metrics)
+type Metrics struct {
+	mu                 sync.Mutex
+	buckets            []float64
+	requests           map[metricsRequest]uint64
+	inFlight           map[metricsRoute]int64
+	phases             map[metricsPhase]*histogram
+	panics             map[metricsRoute]uint64
+	validationFailures map[metricsRoute]uint64
+}
+
+// NewMetrics creates Metrics with the histogram buckets, DefaultMetricsBuckets is used if buckets is empty.
+func NewMetrics(buckets ...float64) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultMetricsBuckets
+	}
+
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	return &Metrics{
+		buckets:            buckets,
+		requests:           make(map[metricsRequest]uint64),
+		inFlight:           make(map[metricsRoute]int64),
+		phases:             make(map[metricsPhase]*histogram),
+		panics:             make(map[metricsRoute]uint64),
+		validationFailures: make(map[metricsRoute]uint64),
+	}
+}
+
+// WithMetrics records the requests of the handler: the requests by route pattern, method and status, the requests
+// in flight, the durations of the argument parsing, the method call and the response encoding, the panics and the
+// validation failures. Handlers used without a router are recorded under the name of the service method.
+func WithMetrics(metrics *Metrics) HandlerOption {
+	return func(h *ServiceHandler) {
+		h.metrics = metrics
+	}
+}
+
+func (m *Metrics) beginRequest(method string, pattern string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	m.inFlight[metricsRoute{method, pattern}]++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) endRequest(method string, pattern string, status int) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	m.inFlight[metricsRoute{method, pattern}]--
+	m.requests[metricsRequest{metricsRoute{method, pattern}, status}]++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) observePhase(method string, pattern string, phase string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := metricsPhase{metricsRoute{method, pattern}, phase}
+	h := m.phases[key]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.phases[key] = h
+	}
+
+	seconds := duration.Seconds()
+	for i, upper := range m.buckets {
+		if seconds <= upper {
+			h.counts[i]++
+		}
+	}
+
+	h.sum += seconds
+	h.count++
+}
+
+func (m *Metrics) countPanic(method string, pattern string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	m.panics[metricsRoute{method, pattern}]++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) countValidationFailure(method string, pattern string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	m.validationFailures[metricsRoute{method, pattern}]++
+	m.mu.Unlock()
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(route metricsRoute, labels ...string) string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, `{method="%s",route="%s"`, labelValueReplacer.Replace(route.method),
+		labelValueReplacer.Replace(route.pattern))
+	for i := 0; i+1 < len(labels); i += 2 {
+		fmt.Fprintf(buf, `,%s="%s"`, labels[i], labelValueReplacer.Replace(labels[i+1]))
+	}
+
+	buf.WriteString("}")
+	return buf.String()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func writeMetricHeader(buf *bytes.Buffer, name string, metricType string, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func lessRoute(a metricsRoute, b metricsRoute) bool {
+	if a.pattern != b.pattern {
+		return a.pattern < b.pattern
+	}
+
+	return a.method < b.method
+}
+
+func writeRouteCounters(buf *bytes.Buffer, name string, metricType string, help string, values map[metricsRoute]int64) {
+	writeMetricHeader(buf, name, metricType, help)
+	var routes []metricsRoute
+	for route := range values {
+		routes = append(routes, route)
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		return lessRoute(routes[i], routes[j])
+	})
+	for _, route := range routes {
+		fmt.Fprintf(buf, "%s%s %d\n", name, formatLabels(route), values[route])
+	}
+}
+
+func toInt64Values(values map[metricsRoute]uint64) map[metricsRoute]int64 {
+	converted := make(map[metricsRoute]int64, len(values))
+	for k, v := range values {
+		converted[k] = int64(v)
+	}
+
+	return converted
+}
+
+// writeText writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) writeText(buf *bytes.Buffer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeMetricHeader(buf, "kellyframework_requests_total", "counter",
+		"Requests by route pattern, method and status.")
+	var requests []metricsRequest
+	for request := range m.requests {
+		requests = append(requests, request)
+	}
+
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i].metricsRoute != requests[j].metricsRoute {
+			return lessRoute(requests[i].metricsRoute, requests[j].metricsRoute)
+		}
+
+		return requests[i].status < requests[j].status
+	})
+	for _, request := range requests {
+		fmt.Fprintf(buf, "kellyframework_requests_total%s %d\n",
+			formatLabels(request.metricsRoute, "status", strconv.Itoa(request.status)), m.requests[request])
+	}
+
+	writeRouteCounters(buf, "kellyframework_requests_in_flight", "gauge", "Requests being served.", m.inFlight)
+
+	writeMetricHeader(buf, "kellyframework_phase_duration_seconds", "histogram",
+		"Durations of the argument parsing, the method call and the response encoding.")
+	var phases []metricsPhase
+	for phase := range m.phases {
+		phases = append(phases, phase)
+	}
+
+	sort.Slice(phases, func(i, j int) bool {
+		if phases[i].metricsRoute != phases[j].metricsRoute {
+			return lessRoute(phases[i].metricsRoute, phases[j].metricsRoute)
+		}
+
+		return phases[i].phase < phases[j].phase
+	})
+	for _, phase := range phases {
+		h := m.phases[phase]
+		for i, upper := range m.buckets {
+			fmt.Fprintf(buf, "kellyframework_phase_duration_seconds_bucket%s %d\n",
+				formatLabels(phase.metricsRoute, "phase", phase.phase, "le", formatFloat(upper)), h.counts[i])
+		}
+
+		fmt.Fprintf(buf, "kellyframework_phase_duration_seconds_bucket%s %d\n",
+			formatLabels(phase.metricsRoute, "phase", phase.phase, "le", "+Inf"), h.count)
+		fmt.Fprintf(buf, "kellyframework_phase_duration_seconds_sum%s %s\n",
+			formatLabels(phase.metricsRoute, "phase", phase.phase), formatFloat(h.sum))
+		fmt.Fprintf(buf, "kellyframework_phase_duration_seconds_count%s %d\n",
+			formatLabels(phase.metricsRoute, "phase", phase.phase), h.count)
+	}
+
+	writeRouteCounters(buf, "kellyframework_panics_total", "counter", "Panics of service methods.",
+		toInt64Values(m.panics))
+	writeRouteCounters(buf, "kellyframework_validation_failures_total", "counter",
+		"Requests whose argument failed the validation.", toInt64Values(m.validationFailures))
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	buf := &bytes.Buffer{}
+	m.writeText(buf)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// requestPhase is one of the parse, call and encode phases of a request, measured by the tracer and the metrics.
+type requestPhase struct {
+	h     *ServiceHandler
+	r     *http.Request
+	name  string
+	begin time.Time
+	span  *Span
+}
+
+var phaseSpanNames = map[string]string{
+	parsePhase:  "parse argument",
+	encodePhase: "encode response",
+}
+
+func (h *ServiceHandler) startPhase(ctx context.Context, r *http.Request, name string) (context.Context,
+	*requestPhase) {
+	phase := &requestPhase{h, r, name, time.Now(), nil}
+	if h.tracer != nil {
+		spanName := phaseSpanNames[name]
+		if name == callPhase {
+			spanName = "call " + functionName(h.method.value.Interface())
+		}
+
+		ctx, phase.span = h.tracer.Start(ctx, spanName, SpanKindInternal)
+	}
+
+	return ctx, phase
+}
+
+func (phase *requestPhase) end(err error) {
+	if err != nil {
+		phase.span.SetStatus(SpanStatusError, err.Error())
+	}
+
+	phase.span.End()
+	phase.h.metrics.observePhase(phase.r.Method, phase.h.routeLabel(), phase.name,
+		time.Now().Sub(phase.begin))
+}
@@ -0,0 +1,98 @@
+package kellyframework
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func meteredFunction(ctx *ServiceMethodContext, arg *struct {
+	Name string `validate:"required"`
+}) (string, error) {
+	if arg.Name == "panic" {
+		panic("boom")
+	}
+
+	return "hello " + arg.Name, nil
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics(0.1, 1)
+	router, err := NewHTTPRouter([]*Route{{Method: "GET", Path: "/hello/\"quoted\"", Function: meteredFunction}},
+		WithMetrics(metrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router.Handler("GET", "/metrics", metrics)
+	for _, target := range []string{"/hello/\"quoted\"?Name=kelly", "/hello/\"quoted\"?Name=kelly",
+		"/hello/\"quoted\"", "/hello/\"quoted\"?Name=panic"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain") {
+		t.Fatal("metrics response is wrong:", recorder.Code, recorder.Header())
+	}
+
+	labels := `method="GET",route="/hello/\"quoted\""`
+	text := recorder.Body.String()
+	for _, expected := range []string{
+		"# TYPE kellyframework_requests_total counter\n",
+		"kellyframework_requests_total{" + labels + `,status="200"} 2` + "\n",
+		"kellyframework_requests_total{" + labels + `,status="400"} 1` + "\n",
+		"kellyframework_requests_total{" + labels + `,status="500"} 1` + "\n",
+		"kellyframework_requests_in_flight{" + labels + "} 0\n",
+		"# TYPE kellyframework_phase_duration_seconds histogram\n",
+		"kellyframework_phase_duration_seconds_bucket{" + labels + `,phase="parse",le="0.1"} 4` + "\n",
+		"kellyframework_phase_duration_seconds_bucket{" + labels + `,phase="parse",le="+Inf"} 4` + "\n",
+		"kellyframework_phase_duration_seconds_count{" + labels + `,phase="call"} 3` + "\n",
+		"kellyframework_phase_duration_seconds_count{" + labels + `,phase="encode"} 4` + "\n",
+		"kellyframework_panics_total{" + labels + "} 1\n",
+		"kellyframework_validation_failures_total{" + labels + "} 1\n",
+	} {
+		if !strings.Contains(text, expected) {
+			t.Error("metrics do not contain", expected, "\n", text)
+		}
+	}
+}
+
+func TestMetricsWithoutRouter(t *testing.T) {
+	metrics := NewMetrics(1)
+	h, err := NewServiceHandlerWithOptions(meteredFunction, WithMetrics(metrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range []string{"/a?Name=kelly", "/b?Name=kelly"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+	}
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	expected := `kellyframework_requests_total{method="GET",route="meteredFunction",status="200"} 2` + "\n"
+	if !strings.Contains(recorder.Body.String(), expected) {
+		t.Error("metrics do not contain", expected, "\n", recorder.Body)
+	}
+}
+
+func streamingFunction(ctx *ServiceMethodContext, arg *struct{}) error {
+	ctx.ResponseBodyWriter.Write([]byte("chunk"))
+	return http.NewResponseController(ctx.ResponseBodyWriter.(http.ResponseWriter)).Flush()
+}
+
+func TestMetricsKeepFlusher(t *testing.T) {
+	h, err := NewServiceHandlerWithOptions(streamingFunction, WithBypassResponseBody(true),
+		WithMetrics(NewMetrics(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	if !recorder.Flushed || recorder.Body.String() != "chunk" {
+		t.Error("response is not flushed:", recorder.Code, recorder.Body)
+	}
+}
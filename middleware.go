@@ -0,0 +1,71 @@
+package kellyframework
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// MiddlewareContext is what a Middleware sees of a request.
+type MiddlewareContext struct {
+	// ServiceMethodContext is passed on to the service method, a middleware may replace its Context to attach
+	// values or deadlines.
+	*ServiceMethodContext
+	Request *http.Request
+	Params  httprouter.Params
+	// Route is the route the request matched, its Path is the pattern instead of the request path.
+	Route *Route
+}
+
+// MiddlewareHandler serves a request after the middleware before it. A returned error is written the same way as
+// an error returned by the service method, so a ServiceMethodError chooses the status code.
+type MiddlewareHandler func(c *MiddlewareContext) error
+
+// Middleware wraps the handling of a request, it may return without calling next to stop the request.
+type Middleware func(next MiddlewareHandler) MiddlewareHandler
+
+// WithMiddleware appends middleware to the handler. Middleware given to the router registration functions runs
+// before the middleware of a route group, which runs before the middleware of a Route.
+//
+// All the middleware runs after the timeout and the body size limit are applied and before the argument is parsed.
+// The access log written by NewLoggingHTTPRouter wraps all of them, so requests stopped by a middleware are logged
+// too, and the method call fields are recorded after the service method returns.
+func WithMiddleware(middleware ...Middleware) HandlerOption {
+	return func(h *ServiceHandler) {
+		h.middleware = append(append([]Middleware{}, h.middleware...), middleware...)
+	}
+}
+
+// routeOf returns the registered route of the handler, handlers used without a router get one built from the
+// request.
+func (h *ServiceHandler) routeOf(r *http.Request) *Route {
+	if h.route != nil {
+		return h.route
+	}
+
+	return &Route{r.Method, r.URL.Path, h.method.value.Interface(), h.bypassRequestBody, h.bypassResponseBody, nil,
+		nil}
+}
+
+// routeLabel names the route in metrics and spans. Handlers used without a router are named after the service method
+// instead of the request path, which would make a series for every path requested.
+func (h *ServiceHandler) routeLabel() string {
+	if h.route != nil {
+		return h.route.Path
+	}
+
+	if name := functionName(h.method.value.Interface()); name != "" {
+		return name
+	}
+
+	return "unmatched"
+}
+
+func (h *ServiceHandler) serveMiddleware(c *MiddlewareContext, last MiddlewareHandler) error {
+	handler := last
+	for i := len(h.middleware) - 1; i >= 0; i-- {
+		handler = h.middleware[i](handler)
+	}
+
+	return handler(c)
+}
@@ -0,0 +1,69 @@
+package kellyframework
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type middlewareContextKey struct{}
+
+func middlewareFunction(ctx *ServiceMethodContext, arg *struct{ Name string }) (string, error) {
+	trail, _ := ctx.Context.Value(middlewareContextKey{}).(string)
+	return trail + "method(" + arg.Name + ")", nil
+}
+
+func traceMiddleware(name string) Middleware {
+	return func(next MiddlewareHandler) MiddlewareHandler {
+		return func(c *MiddlewareContext) error {
+			trail, _ := c.Context.Value(middlewareContextKey{}).(string)
+			c.Context = context.WithValue(c.Context, middlewareContextKey{}, trail+name+"("+c.Route.Path+") ")
+			return next(c)
+		}
+	}
+}
+
+func authMiddleware(next MiddlewareHandler) MiddlewareHandler {
+	return func(c *MiddlewareContext) error {
+		if c.RequestHeader.Get("Authorization") == "" {
+			return NewServiceMethodError(http.StatusUnauthorized, "unauthorized", nil)
+		}
+
+		return next(c)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	router, err := NewHTTPRouter([]*Route{
+		{Method: "GET", Path: "/hello/:Name", Function: middlewareFunction,
+			Options:    []HandlerOption{WithMiddleware(traceMiddleware("options"))},
+			Middleware: []Middleware{traceMiddleware("route")}},
+		{Method: "GET", Path: "/private", Function: middlewareFunction, Middleware: []Middleware{authMiddleware}},
+	}, WithMiddleware(traceMiddleware("router")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/hello/kelly", nil))
+	expected := "router(/hello/:Name) options(/hello/:Name) route(/hello/:Name) method(kelly)"
+	if !strings.Contains(recorder.Body.String(), expected) {
+		t.Error("middleware order is wrong:", recorder.Body)
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/private?Name=kelly", nil))
+	if recorder.Code != http.StatusUnauthorized || !strings.Contains(recorder.Body.String(), `"msg":"unauthorized"`) {
+		t.Error("stopped request is wrong:", recorder.Code, recorder.Body)
+	}
+
+	recorder = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/private?Name=kelly", nil)
+	req.Header.Set("Authorization", "token")
+	router.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "router(/private) method(kelly)") {
+		t.Error("authorized request is wrong:", recorder.Code, recorder.Body)
+	}
+}
@@ -0,0 +1,408 @@
+package kellyframework
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+var timeType = reflect.TypeOf(time.Time{})
+var jsonNumberType = reflect.TypeOf(json.Number(""))
+
+// encodeMsgpack writes v in MessagePack format. Structs are encoded as maps keyed like encoding/json would, so a
+// client sees the same field names whichever format it asked for.
+func encodeMsgpack(w io.Writer, v interface{}) error {
+	buf := &bytes.Buffer{}
+	err := writeMsgpackValue(buf, reflect.ValueOf(v))
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+func writeMsgpackUint(buf *bytes.Buffer, prefix byte, n uint64, size int) {
+	buf.WriteByte(prefix)
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	buf.Write(b[8-size:])
+}
+
+func writeMsgpackInt(buf *bytes.Buffer, n int64) {
+	switch {
+	case n >= 0:
+		writeMsgpackUnsigned(buf, uint64(n))
+	case n >= -32:
+		buf.WriteByte(byte(n))
+	case n >= math.MinInt8:
+		writeMsgpackUint(buf, 0xd0, uint64(n), 1)
+	case n >= math.MinInt16:
+		writeMsgpackUint(buf, 0xd1, uint64(n), 2)
+	case n >= math.MinInt32:
+		writeMsgpackUint(buf, 0xd2, uint64(n), 4)
+	default:
+		writeMsgpackUint(buf, 0xd3, uint64(n), 8)
+	}
+}
+
+func writeMsgpackUnsigned(buf *bytes.Buffer, n uint64) {
+	switch {
+	case n <= 0x7f:
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint8:
+		writeMsgpackUint(buf, 0xcc, n, 1)
+	case n <= math.MaxUint16:
+		writeMsgpackUint(buf, 0xcd, n, 2)
+	case n <= math.MaxUint32:
+		writeMsgpackUint(buf, 0xce, n, 4)
+	default:
+		writeMsgpackUint(buf, 0xcf, n, 8)
+	}
+}
+
+func writeMsgpackString(buf *bytes.Buffer, s string) {
+	n := uint64(len(s))
+	switch {
+	case n <= 31:
+		buf.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		writeMsgpackUint(buf, 0xd9, n, 1)
+	case n <= math.MaxUint16:
+		writeMsgpackUint(buf, 0xda, n, 2)
+	default:
+		writeMsgpackUint(buf, 0xdb, n, 4)
+	}
+
+	buf.WriteString(s)
+}
+
+func writeMsgpackBinary(buf *bytes.Buffer, b []byte) {
+	n := uint64(len(b))
+	switch {
+	case n <= math.MaxUint8:
+		writeMsgpackUint(buf, 0xc4, n, 1)
+	case n <= math.MaxUint16:
+		writeMsgpackUint(buf, 0xc5, n, 2)
+	default:
+		writeMsgpackUint(buf, 0xc6, n, 4)
+	}
+
+	buf.Write(b)
+}
+
+func writeMsgpackArrayHeader(buf *bytes.Buffer, n int) {
+	switch {
+	case n <= 15:
+		buf.WriteByte(0x90 | byte(n))
+	case n <= math.MaxUint16:
+		writeMsgpackUint(buf, 0xdc, uint64(n), 2)
+	default:
+		writeMsgpackUint(buf, 0xdd, uint64(n), 4)
+	}
+}
+
+func writeMsgpackMapHeader(buf *bytes.Buffer, n int) {
+	switch {
+	case n <= 15:
+		buf.WriteByte(0x80 | byte(n))
+	case n <= math.MaxUint16:
+		writeMsgpackUint(buf, 0xde, uint64(n), 2)
+	default:
+		writeMsgpackUint(buf, 0xdf, uint64(n), 4)
+	}
+}
+
+// writeMsgpackViaJSON encodes values with custom JSON marshaling by their JSON representation.
+func writeMsgpackViaJSON(buf *bytes.Buffer, m json.Marshaler) error {
+	data, err := m.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	var decoded interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err = decoder.Decode(&decoded)
+	if err != nil {
+		return err
+	}
+
+	return writeMsgpackValue(buf, reflect.ValueOf(decoded))
+}
+
+func writeMsgpackValue(buf *bytes.Buffer, v reflect.Value) error {
+	if !v.IsValid() {
+		buf.WriteByte(0xc0)
+		return nil
+	}
+
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		buf.WriteByte(0xc0)
+		return nil
+	}
+
+	if v.Type() == jsonNumberType {
+		n := json.Number(v.String())
+		if i, err := n.Int64(); err == nil {
+			writeMsgpackInt(buf, i)
+			return nil
+		}
+
+		f, err := n.Float64()
+		if err != nil {
+			return err
+		}
+
+		return writeMsgpackValue(buf, reflect.ValueOf(f))
+	}
+
+	// values reached through unexported embedded structs can not be converted to interfaces, they are encoded
+	// by their kinds.
+	if v.CanInterface() {
+		if v.Type() == timeType {
+			writeMsgpackString(buf, v.Interface().(time.Time).Format(time.RFC3339Nano))
+			return nil
+		}
+
+		if v.Type().Implements(jsonMarshalerType) {
+			return writeMsgpackViaJSON(buf, v.Interface().(json.Marshaler))
+		}
+
+		if v.Kind() != reflect.Ptr && v.CanAddr() && reflect.PtrTo(v.Type()).Implements(jsonMarshalerType) {
+			return writeMsgpackViaJSON(buf, v.Addr().Interface().(json.Marshaler))
+		}
+
+		if v.Type().Implements(textMarshalerType) {
+			text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+			if err != nil {
+				return err
+			}
+
+			writeMsgpackString(buf, string(text))
+			return nil
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return writeMsgpackValue(buf, v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		writeMsgpackInt(buf, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		writeMsgpackUnsigned(buf, v.Uint())
+	case reflect.Float32:
+		writeMsgpackUint(buf, 0xca, uint64(math.Float32bits(float32(v.Float()))), 4)
+	case reflect.Float64:
+		writeMsgpackUint(buf, 0xcb, math.Float64bits(v.Float()), 8)
+	case reflect.String:
+		writeMsgpackString(buf, v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			buf.WriteByte(0xc0)
+			return nil
+		}
+
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			writeMsgpackBinary(buf, b)
+			return nil
+		}
+
+		writeMsgpackArrayHeader(buf, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			err := writeMsgpackValue(buf, v.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			buf.WriteByte(0xc0)
+			return nil
+		}
+
+		writeMsgpackMapHeader(buf, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			err := writeMsgpackValue(buf, iter.Key())
+			if err != nil {
+				return err
+			}
+
+			err = writeMsgpackValue(buf, iter.Value())
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		return writeMsgpackStruct(buf, v)
+	default:
+		return fmt.Errorf("msgpack: unsupported type %s", v.Type())
+	}
+
+	return nil
+}
+
+type msgpackField struct {
+	name   string
+	value  reflect.Value
+	depth  int
+	tagged bool
+	// omitted is set for fields which are empty with 'omitempty' or reached through nil embedded pointers, they still
+	// hide the fields of the same name deeper in the struct.
+	omitted bool
+}
+
+// isEmptyMsgpackValue reports the values 'omitempty' leaves out, the same ones as encoding/json.
+func isEmptyMsgpackValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+
+	return false
+}
+
+// collectMsgpackFields follows the encoding/json rules: exported fields, 'json' tag names, 'omitempty', '-', and
+// embedded structs without a tag name are inlined. v is invalid for the structs behind nil embedded pointers, path
+// holds the embedded struct types being collected, so embedding cycles are not followed.
+func collectMsgpackFields(v reflect.Value, t reflect.Type, path map[reflect.Type]bool,
+	fields []msgpackField) []msgpackField {
+	path[t] = true
+	defer delete(path, t)
+	omitted := !v.IsValid()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		parts := strings.Split(tag, ",")
+		name := parts[0]
+		var fv reflect.Value
+		if !omitted {
+			fv = v.Field(i)
+		}
+
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+				if fv.IsValid() && fv.IsNil() {
+					fv = reflect.Value{}
+				} else if fv.IsValid() {
+					fv = fv.Elem()
+				}
+			}
+
+			if ft.Kind() == reflect.Struct {
+				if !path[ft] {
+					fields = collectMsgpackFields(fv, ft, path, fields)
+				}
+
+				continue
+			}
+		}
+
+		if field.PkgPath != "" {
+			continue
+		}
+
+		tagged := name != ""
+		if !tagged {
+			name = field.Name
+		}
+
+		omitEmpty := false
+		for _, option := range parts[1:] {
+			if option == "omitempty" {
+				omitEmpty = true
+			}
+		}
+
+		fields = append(fields, msgpackField{name, fv, len(path), tagged, omitted || omitEmpty && isEmptyMsgpackValue(fv)})
+	}
+
+	return fields
+}
+
+// dominantMsgpackFields resolves the fields of the same name like encoding/json: the shallowest field wins, a tagged
+// one wins among the shallowest, otherwise none of them is encoded.
+func dominantMsgpackFields(fields []msgpackField) []msgpackField {
+	byName := make(map[string][]int)
+	for i, field := range fields {
+		byName[field.name] = append(byName[field.name], i)
+	}
+
+	var dominant []msgpackField
+	for i, field := range fields {
+		var shallowest []int
+		for _, j := range byName[field.name] {
+			if len(shallowest) == 0 || fields[j].depth < fields[shallowest[0]].depth {
+				shallowest = []int{j}
+			} else if fields[j].depth == fields[shallowest[0]].depth {
+				shallowest = append(shallowest, j)
+			}
+		}
+
+		winner := -1
+		for _, j := range shallowest {
+			if len(shallowest) == 1 || fields[j].tagged {
+				if winner >= 0 {
+					winner = -1
+					break
+				}
+
+				winner = j
+			}
+		}
+
+		if winner == i && !field.omitted {
+			dominant = append(dominant, field)
+		}
+	}
+
+	return dominant
+}
+
+func writeMsgpackStruct(buf *bytes.Buffer, v reflect.Value) error {
+	fields := dominantMsgpackFields(collectMsgpackFields(v, v.Type(), map[reflect.Type]bool{}, nil))
+	writeMsgpackMapHeader(buf, len(fields))
+	for _, field := range fields {
+		writeMsgpackString(buf, field.name)
+		err := writeMsgpackValue(buf, field.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package kellyframework
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+type msgpackInner struct {
+	Name string
+}
+
+type msgpackOuter struct {
+	msgpackInner
+	ID     int64             `json:"id"`
+	Skip   string            `json:"-"`
+	Empty  string            `json:"empty,omitempty"`
+	Tags   []string          `json:"tags"`
+	Raw    json.RawMessage   `json:"raw"`
+	Attrs  map[string]uint16 `json:"attrs"`
+	hidden int
+}
+
+type msgpackTagged struct {
+	Title string `json:"Name"`
+}
+
+type msgpackLeft struct {
+	Dup int
+}
+
+type msgpackRight struct {
+	Dup int
+}
+
+type msgpackDominance struct {
+	msgpackInner
+	msgpackTagged
+	msgpackLeft
+	msgpackRight
+}
+
+type msgpackShadowed struct {
+	msgpackInner
+	Name string
+}
+
+func TestEncodeMsgpack(t *testing.T) {
+	cases := []struct {
+		name     string
+		value    interface{}
+		expected []byte
+	}{
+		{"nil", nil, []byte{0xc0}},
+		{"bool", true, []byte{0xc3}},
+		{"positive fixint", 1, []byte{0x01}},
+		{"negative fixint", -1, []byte{0xff}},
+		{"int16", -200, []byte{0xd1, 0xff, 0x38}},
+		{"uint16", 300, []byte{0xcd, 0x01, 0x2c}},
+		{"float64", 1.5, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"string", "ab", []byte{0xa2, 'a', 'b'}},
+		{"binary", []byte{1, 2}, []byte{0xc4, 0x02, 1, 2}},
+		{"struct", &struct{ A int }{1}, []byte{0x81, 0xa1, 'A', 0x01}},
+		{"embedded and tags", &msgpackOuter{msgpackInner{"n"}, 7, "s", "", []string{"x"}, json.RawMessage("[1]"),
+			map[string]uint16{"k": 2}, 0}, []byte{0x85, 0xa4, 'N', 'a', 'm', 'e', 0xa1, 'n', 0xa2, 'i', 'd', 0x07,
+			0xa4, 't', 'a', 'g', 's', 0x91, 0xa1, 'x', 0xa3, 'r', 'a', 'w', 0x91, 0x01,
+			0xa5, 'a', 't', 't', 'r', 's', 0x81, 0xa1, 'k', 0x02}},
+		{"omitempty", &struct {
+			S []string       `json:"s,omitempty"`
+			M map[string]int `json:"m,omitempty"`
+			P *int           `json:"p,omitempty"`
+			B bool           `json:"b,omitempty"`
+		}{[]string{}, map[string]int{}, nil, false}, []byte{0x80}},
+		{"tagged field dominates", &msgpackDominance{msgpackInner{"n"}, msgpackTagged{"t"}, msgpackLeft{1},
+			msgpackRight{2}}, []byte{0x81, 0xa4, 'N', 'a', 'm', 'e', 0xa1, 't'}},
+		{"shallow field dominates", &msgpackShadowed{msgpackInner{"n"}, "o"},
+			[]byte{0x81, 0xa4, 'N', 'a', 'm', 'e', 0xa1, 'o'}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			if err := encodeMsgpack(buf, c.value); err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(buf.Bytes(), c.expected) {
+				t.Errorf("expected % x, got % x", c.expected, buf.Bytes())
+			}
+		})
+	}
+}
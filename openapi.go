@@ -0,0 +1,528 @@
+package kellyframework
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenAPIDocument is an OpenAPI 3 document describing routes, it is an http.Handler serving itself as JSON.
+type OpenAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       OpenAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+	Components OpenAPIComponents                       `json:"components"`
+}
+
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type OpenAPIComponents struct {
+	Schemas map[string]*OpenAPISchema `json:"schemas"`
+}
+
+type OpenAPIOperation struct {
+	OperationID string                      `json:"operationId,omitempty"`
+	Parameters  []*OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse `json:"responses"`
+}
+
+type OpenAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required,omitempty"`
+	Schema   *OpenAPISchema `json:"schema"`
+}
+
+type OpenAPIRequestBody struct {
+	Required bool                         `json:"required,omitempty"`
+	Content  map[string]*OpenAPIMediaType `json:"content"`
+}
+
+type OpenAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*OpenAPIMediaType `json:"content,omitempty"`
+}
+
+type OpenAPIMediaType struct {
+	Schema *OpenAPISchema `json:"schema"`
+}
+
+type OpenAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+	Properties           map[string]*OpenAPISchema `json:"properties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	Items                *OpenAPISchema            `json:"items,omitempty"`
+	AdditionalProperties *OpenAPISchema            `json:"additionalProperties,omitempty"`
+	Enum                 []interface{}             `json:"enum,omitempty"`
+	Minimum              *float64                  `json:"minimum,omitempty"`
+	Maximum              *float64                  `json:"maximum,omitempty"`
+	ExclusiveMinimum     bool                      `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     bool                      `json:"exclusiveMaximum,omitempty"`
+	MinLength            *int                      `json:"minLength,omitempty"`
+	MaxLength            *int                      `json:"maxLength,omitempty"`
+	MinItems             *int                      `json:"minItems,omitempty"`
+	MaxItems             *int                      `json:"maxItems,omitempty"`
+}
+
+type openAPIGenerator struct {
+	schemas map[string]*OpenAPISchema
+	names   map[reflect.Type]string
+}
+
+var httprouterParamPattern = regexp.MustCompile(`[:*]([^/]+)`)
+var formattedResponseType = reflect.TypeOf((*FormattedResponse)(nil))
+
+// NewOpenAPIDocument describes the routes. Argument fields become path, query, header or cookie parameters or the
+// request body according to their sources, and 'validate' tags become schema constraints.
+func NewOpenAPIDocument(title string, version string, routes []*Route) (*OpenAPIDocument, error) {
+	g := &openAPIGenerator{make(map[string]*OpenAPISchema), make(map[reflect.Type]string)}
+	doc := &OpenAPIDocument{
+		"3.0.3",
+		OpenAPIInfo{title, version},
+		make(map[string]map[string]*OpenAPIOperation),
+		OpenAPIComponents{g.schemas},
+	}
+
+	for _, rt := range routes {
+		operation, err := g.operation(rt)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %s", rt.Method, rt.Path, err)
+		}
+
+		path := openAPIPath(rt.Path)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*OpenAPIOperation)
+		}
+
+		doc.Paths[path][strings.ToLower(rt.Method)] = operation
+	}
+
+	return doc, nil
+}
+
+func (doc *OpenAPIDocument) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(doc)
+}
+
+// openAPIPath converts httprouter patterns like '/user/:Name' to '/user/{Name}'.
+func openAPIPath(path string) string {
+	return httprouterParamPattern.ReplaceAllString(path, "{$1}")
+}
+
+func functionName(fn interface{}) string {
+	name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+	name = strings.TrimSuffix(name, "-fm")
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+
+	return name
+}
+
+func requestHasBody(rt *Route) bool {
+	if rt.BypassRequestBody {
+		return false
+	}
+
+	switch strings.ToUpper(rt.Method) {
+	case "POST", "PUT", "PATCH":
+		return true
+	}
+
+	return false
+}
+
+func isFileType(t reflect.Type) bool {
+	return t == uploadedFileType || t == fileHeaderType || t == reflect.SliceOf(uploadedFileType) ||
+		t == reflect.SliceOf(fileHeaderType)
+}
+
+func (g *openAPIGenerator) operation(rt *Route) (*OpenAPIOperation, error) {
+	methodType := reflect.TypeOf(rt.Function)
+	err := checkServiceMethodPrototype(methodType)
+	if err != nil {
+		return nil, err
+	}
+
+	argType := methodType.In(1).Elem()
+	binding, err := newArgumentBinding(argType)
+	if err != nil {
+		return nil, err
+	}
+
+	operation := &OpenAPIOperation{
+		OperationID: functionName(rt.Function),
+		Responses:   make(map[string]*OpenAPIResponse),
+	}
+
+	var pathParams []string
+	for _, match := range httprouterParamPattern.FindAllStringSubmatch(rt.Path, -1) {
+		pathParams = append(pathParams, match[1])
+	}
+
+	// explicitly bound fields first, they can not be set by the other sources.
+	bound := make(map[string]bool)
+	for _, f := range binding.fields {
+		bound[f.field.Name] = true
+		if f.source == SourceBody {
+			continue
+		}
+
+		operation.Parameters = append(operation.Parameters, &OpenAPIParameter{f.name, f.source,
+			f.source == SourcePath || hasValidateRule(f.field, "required"), g.fieldSchema(f.field)})
+	}
+
+	fieldsByPathParam := make(map[string]reflect.StructField)
+	for _, param := range pathParams {
+		for _, field := range binding.settable {
+			if strings.EqualFold(formFieldName(field), param) {
+				fieldsByPathParam[param] = field
+			}
+		}
+	}
+
+	for _, param := range pathParams {
+		schema := &OpenAPISchema{Type: "string"}
+		if field, ok := fieldsByPathParam[param]; ok {
+			schema = g.fieldSchema(field)
+			bound[field.Name] = true
+		}
+
+		if !hasParameter(operation.Parameters, param, SourcePath) {
+			operation.Parameters = append(operation.Parameters, &OpenAPIParameter{param, SourcePath, true, schema})
+		}
+	}
+
+	if requestHasBody(rt) {
+		operation.RequestBody = g.requestBody(binding.settable, bound)
+	} else {
+		for _, field := range binding.settable {
+			if bound[field.Name] || isFileType(field.Type) {
+				continue
+			}
+
+			operation.Parameters = append(operation.Parameters, &OpenAPIParameter{formFieldName(field), SourceQuery,
+				hasValidateRule(field, "required"), g.fieldSchema(field)})
+		}
+	}
+
+	errorContent := map[string]*OpenAPIMediaType{"application/json": {g.schema(formattedResponseType)}}
+	operation.Responses["200"] = g.successResponse(methodType.Out(0), rt.BypassResponseBody)
+	operation.Responses["400"] = &OpenAPIResponse{"the argument can not be parsed or is invalid", errorContent}
+	operation.Responses["500"] = &OpenAPIResponse{"the service method returned an error or panicked", errorContent}
+	return operation, nil
+}
+
+func hasParameter(params []*OpenAPIParameter, name string, in string) bool {
+	for _, p := range params {
+		if p.In == in && strings.EqualFold(p.Name, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (g *openAPIGenerator) requestBody(fields []reflect.StructField, bound map[string]bool) *OpenAPIRequestBody {
+	schema := &OpenAPISchema{Type: "object", Properties: make(map[string]*OpenAPISchema)}
+	multipartSchema := &OpenAPISchema{Type: "object", Properties: make(map[string]*OpenAPISchema)}
+	hasFile := false
+	for _, field := range fields {
+		if bound[field.Name] && !isBodyBound(field) {
+			continue
+		}
+
+		if isFileType(field.Type) {
+			hasFile = true
+		} else {
+			name := jsonFieldName(field)
+			if field.Tag.Get("json") == "-" {
+				continue
+			}
+
+			schema.Properties[name] = g.fieldSchema(field)
+			if hasValidateRule(field, "required") {
+				schema.Required = append(schema.Required, name)
+			}
+		}
+
+		name := formFieldName(field)
+		multipartSchema.Properties[name] = g.fieldSchema(field)
+		if hasValidateRule(field, "required") {
+			multipartSchema.Required = append(multipartSchema.Required, name)
+		}
+	}
+
+	if hasFile {
+		return &OpenAPIRequestBody{true, map[string]*OpenAPIMediaType{"multipart/form-data": {multipartSchema}}}
+	}
+
+	return &OpenAPIRequestBody{len(schema.Required) > 0, map[string]*OpenAPIMediaType{
+		"application/json":                  {schema},
+		"application/x-www-form-urlencoded": {multipartSchema},
+	}}
+}
+
+func isBodyBound(field reflect.StructField) bool {
+	source, _, _ := parseSourceTag(field)
+	return source == SourceBody
+}
+
+func (g *openAPIGenerator) successResponse(t reflect.Type, bypassResponseBody bool) *OpenAPIResponse {
+	if bypassResponseBody || t == errorType || t == formattedResponseType {
+		return &OpenAPIResponse{Description: "the service method succeeded"}
+	}
+
+	return &OpenAPIResponse{"the service method succeeded",
+		map[string]*OpenAPIMediaType{"application/json": {g.schema(t)}}}
+}
+
+func validateRules(field reflect.StructField) []string {
+	tag := field.Tag.Get("validate")
+	if tag == "" {
+		return nil
+	}
+
+	// rules after 'dive' apply to the elements.
+	rules := strings.Split(tag, ",")
+	for i, rule := range rules {
+		if rule == "dive" {
+			return rules[:i]
+		}
+	}
+
+	return rules
+}
+
+func hasValidateRule(field reflect.StructField, name string) bool {
+	for _, rule := range validateRules(field) {
+		if rule == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fieldSchema is the schema of the field type with the constraints of its 'validate' tag.
+func (g *openAPIGenerator) fieldSchema(field reflect.StructField) *OpenAPISchema {
+	schema := g.schema(field.Type)
+	rules := validateRules(field)
+	if len(rules) == 0 {
+		return schema
+	}
+
+	if schema.Ref != "" {
+		// constraints can not be put beside a reference in OpenAPI 3.0.
+		return schema
+	}
+
+	copied := *schema
+	schema = &copied
+	for _, rule := range rules {
+		parts := strings.SplitN(rule, "=", 2)
+		param := ""
+		if len(parts) == 2 {
+			param = parts[1]
+		}
+
+		applyValidateRule(schema, parts[0], param)
+	}
+
+	return schema
+}
+
+func applyValidateRule(schema *OpenAPISchema, rule string, param string) {
+	number, numberErr := strconv.ParseFloat(param, 64)
+	length, lengthErr := strconv.Atoi(param)
+	switch rule {
+	case "min", "max", "len", "gt", "gte", "lt", "lte":
+		switch {
+		case (schema.Type == "integer" || schema.Type == "number") && numberErr == nil:
+			if rule == "min" || rule == "gte" || rule == "gt" || rule == "len" {
+				schema.Minimum = &number
+				schema.ExclusiveMinimum = rule == "gt"
+			}
+
+			if rule == "max" || rule == "lte" || rule == "lt" || rule == "len" {
+				schema.Maximum = &number
+				schema.ExclusiveMaximum = rule == "lt"
+			}
+		case schema.Type == "string" && lengthErr == nil:
+			if rule == "min" || rule == "gte" || rule == "len" {
+				schema.MinLength = &length
+			}
+
+			if rule == "max" || rule == "lte" || rule == "len" {
+				schema.MaxLength = &length
+			}
+		case schema.Type == "array" && lengthErr == nil:
+			if rule == "min" || rule == "gte" || rule == "len" {
+				schema.MinItems = &length
+			}
+
+			if rule == "max" || rule == "lte" || rule == "len" {
+				schema.MaxItems = &length
+			}
+		}
+	case "oneof":
+		for _, value := range strings.Fields(param) {
+			if schema.Type == "integer" || schema.Type == "number" {
+				if n, err := strconv.ParseFloat(value, 64); err == nil {
+					schema.Enum = append(schema.Enum, n)
+				}
+			} else {
+				schema.Enum = append(schema.Enum, value)
+			}
+		}
+	case "email":
+		schema.Format = "email"
+	case "url", "uri":
+		schema.Format = "uri"
+	case "uuid", "uuid4":
+		schema.Format = "uuid"
+	case "ipv4", "ipv6":
+		schema.Format = rule
+	}
+}
+
+func (g *openAPIGenerator) schemaName(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+
+	name := t.Name()
+	if name == "" {
+		name = "Anonymous"
+	}
+
+	for i := 2; ; i++ {
+		if _, ok := g.schemas[name]; !ok {
+			break
+		}
+
+		name = fmt.Sprintf("%s%d", t.Name(), i)
+	}
+
+	g.names[t] = name
+	return name
+}
+
+// schema converts a Go type to its JSON schema, named structs are put into the components and referenced.
+func (g *openAPIGenerator) schema(t reflect.Type) *OpenAPISchema {
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return &OpenAPISchema{Type: "string", Format: "date-time"}
+	case uploadedFileType, fileHeaderType:
+		return &OpenAPISchema{Type: "string", Format: "binary"}
+	case reflect.TypeOf(multipart.FileHeader{}):
+		return &OpenAPISchema{Type: "string", Format: "binary"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := g.schema(t.Elem())
+		if schema.Ref != "" {
+			return schema
+		}
+
+		copied := *schema
+		copied.Nullable = true
+		return &copied
+	case reflect.Bool:
+		return &OpenAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return &OpenAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int64:
+		return &OpenAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		zero := 0.0
+		return &OpenAPISchema{Type: "integer", Minimum: &zero}
+	case reflect.Float32:
+		return &OpenAPISchema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &OpenAPISchema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &OpenAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &OpenAPISchema{Type: "string", Format: "byte"}
+		}
+
+		return &OpenAPISchema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &OpenAPISchema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+
+		name, known := g.names[t]
+		if !known {
+			name = g.schemaName(t)
+			// registered before the fields are walked, so recursive types end up referencing themselves.
+			g.schemas[name] = &OpenAPISchema{}
+			*g.schemas[name] = *g.structSchema(t)
+		}
+
+		return &OpenAPISchema{Ref: "#/components/schemas/" + name}
+	}
+
+	// interface{} and the others can be anything.
+	return &OpenAPISchema{}
+}
+
+func (g *openAPIGenerator) structSchema(t reflect.Type) *OpenAPISchema {
+	schema := &OpenAPISchema{Type: "object", Properties: make(map[string]*OpenAPISchema)}
+	g.collectProperties(t, schema)
+	sort.Strings(schema.Required)
+	return schema
+}
+
+func (g *openAPIGenerator) collectProperties(t reflect.Type, schema *OpenAPISchema) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		if field.Anonymous && strings.SplitN(tag, ",", 2)[0] == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+
+			if ft.Kind() == reflect.Struct {
+				g.collectProperties(ft, schema)
+				continue
+			}
+		}
+
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := jsonFieldName(field)
+		schema.Properties[name] = g.fieldSchema(field)
+		if hasValidateRule(field, "required") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package kellyframework
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type openAPIUserName struct {
+	Name string `validate:"required,max=32"`
+}
+
+type openAPIUserInfo struct {
+	Age     int    `json:"age" validate:"min=0,max=140"`
+	Address string `json:"address,omitempty" validate:"oneof=beijing shanghai"`
+}
+
+type openAPIUser struct {
+	openAPIUserName
+	openAPIUserInfo
+	RequestID string `kelly:"header=X-Request-Id"`
+	Friends   []*openAPIUser
+}
+
+func openAPIAddUser(*ServiceMethodContext, *openAPIUser) error {
+	return nil
+}
+
+func openAPIGetUser(*ServiceMethodContext, *openAPIUserName) (*openAPIUserInfo, error) {
+	return nil, nil
+}
+
+func TestNewOpenAPIDocument(t *testing.T) {
+	doc, err := NewOpenAPIDocument("users", "1.0", []*Route{
+		{Method: "POST", Path: "/user/:Name", Function: openAPIAddUser},
+		{Method: "GET", Path: "/user/:Name", Function: openAPIGetUser, BypassRequestBody: true},
+		{Method: "GET", Path: "/users/", Function: openAPIGetUser},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := doc.Paths["/user/{Name}"]["post"]
+	if post == nil || post.OperationID != "openAPIAddUser" {
+		t.Fatal("post operation is wrong:", post)
+	}
+
+	expectedParams := []*OpenAPIParameter{
+		{"X-Request-Id", "header", false, &OpenAPISchema{Type: "string"}},
+		{"Name", "path", true, &OpenAPISchema{Type: "string", MaxLength: intPointer(32)}},
+	}
+	if !reflect.DeepEqual(post.Parameters, expectedParams) {
+		data, _ := json.Marshal(post.Parameters)
+		t.Error("post parameters are wrong:", string(data))
+	}
+
+	body := post.RequestBody.Content["application/json"].Schema
+	if _, ok := body.Properties["Name"]; ok {
+		t.Error("path param is in the body")
+	}
+
+	if body.Properties["age"].Maximum == nil || *body.Properties["age"].Maximum != 140 ||
+		!reflect.DeepEqual(body.Properties["address"].Enum, []interface{}{"beijing", "shanghai"}) ||
+		body.Properties["Friends"].Items.Ref != "#/components/schemas/openAPIUser" {
+		data, _ := json.Marshal(body)
+		t.Error("post body is wrong:", string(data))
+	}
+
+	if doc.Components.Schemas["openAPIUser"].Properties["Friends"].Items.Ref != "#/components/schemas/openAPIUser" {
+		t.Error("recursive schema is wrong")
+	}
+
+	list := doc.Paths["/users/"]["get"]
+	if len(list.Parameters) != 1 || list.Parameters[0].In != "query" || !list.Parameters[0].Required {
+		t.Error("query parameters are wrong:", list.Parameters)
+	}
+
+	if list.Responses["200"].Content["application/json"].Schema.Ref != "#/components/schemas/openAPIUserInfo" {
+		t.Error("response schema is wrong")
+	}
+
+	recorder := httptest.NewRecorder()
+	doc.ServeHTTP(recorder, httptest.NewRequest("GET", "/openapi.json", nil))
+	var served map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &served); err != nil || served["openapi"] != "3.0.3" {
+		t.Error("served document is wrong:", recorder.Body)
+	}
+}
+
+func intPointer(i int) *int {
+	return &i
+}
@@ -0,0 +1,177 @@
+package kellyframework
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// OTLPExporter exports spans to an OpenTelemetry collector with OTLP/HTTP in the JSON encoding, e.g.
+// "http://127.0.0.1:4318/v1/traces".
+type OTLPExporter struct {
+	URL         string
+	ServiceName string
+	Client      *http.Client
+	// Header is added to every request, e.g. the authentication header of the collector.
+	Header http.Header
+}
+
+func NewOTLPExporter(url string, serviceName string) *OTLPExporter {
+	return &OTLPExporter{url, serviceName, &http.Client{Timeout: 5 * time.Second}, http.Header{}}
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    SpanStatusCode `json:"code,omitempty"`
+	Message string         `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	TraceState        string         `json:"traceState,omitempty"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []*otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []*otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpTraceRequest struct {
+	ResourceSpans []*otlpResourceSpans `json:"resourceSpans"`
+}
+
+func otlpAttribute(key string, value interface{}) otlpKeyValue {
+	kv := otlpKeyValue{Key: key}
+	switch v := value.(type) {
+	case bool:
+		kv.Value.BoolValue = &v
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32:
+		// 64 bits integers are strings in the JSON encoding of protobuf.
+		s := fmt.Sprint(v)
+		kv.Value.IntValue = &s
+	case float32:
+		f := float64(v)
+		kv.Value.DoubleValue = &f
+	case float64:
+		kv.Value.DoubleValue = &v
+	default:
+		s := fmt.Sprint(v)
+		kv.Value.StringValue = &s
+	}
+
+	return kv
+}
+
+func otlpAttributes(attributes map[string]interface{}) []otlpKeyValue {
+	var kvs []otlpKeyValue
+	for _, key := range sortedKeys(attributes) {
+		kvs = append(kvs, otlpAttribute(key, attributes[key]))
+	}
+
+	return kvs
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func newOTLPTraceRequest(serviceName string, spans []*Span) *otlpTraceRequest {
+	scopeSpans := &otlpScopeSpans{}
+	scopeSpans.Scope.Name = frameworkImportPath()
+	for _, span := range spans {
+		s := &otlpSpan{
+			TraceID:           span.SpanContext.TraceID.String(),
+			SpanID:            span.SpanContext.SpanID.String(),
+			TraceState:        span.SpanContext.TraceState,
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.Attributes),
+			Status:            otlpStatus{span.StatusCode, span.StatusMessage},
+		}
+		if span.Parent.IsValid() {
+			s.ParentSpanID = span.Parent.SpanID.String()
+		}
+
+		scopeSpans.Spans = append(scopeSpans.Spans, s)
+	}
+
+	resourceSpans := &otlpResourceSpans{ScopeSpans: []*otlpScopeSpans{scopeSpans}}
+	resourceSpans.Resource.Attributes = []otlpKeyValue{otlpAttribute("service.name", serviceName)}
+	return &otlpTraceRequest{[]*otlpResourceSpans{resourceSpans}}
+}
+
+func (e *OTLPExporter) ExportSpans(spans []*Span) error {
+	body, err := json.Marshal(newOTLPTraceRequest(e.ServiceName, spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", e.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	for k, v := range e.Header {
+		req.Header[k] = v
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package kellyframework
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+)
+
+// PanicPolicy decides what is done with a panic of a service method or an interceptor. The zero value is safe for
+// production: clients only get an opaque incident ID, the panic value and the stack go to the method call log and
+// ErrorLog, both tagged with the incident ID.
+type PanicPolicy struct {
+	// Debug writes the panic value and the stack to the response too, never enable it on public services.
+	Debug bool
+	// RepanicAbortHandler panics again with http.ErrAbortHandler when the method panicked with it, so that the
+	// http server aborts the response without logging a stack.
+	RepanicAbortHandler bool
+	// ErrorLog receives a line with the incident ID, the panic value and the stack of every panic.
+	ErrorLog *log.Logger
+}
+
+// PanicIncident is the failure data of panics, it lets the operators find the stack of a panic reported by users.
+type PanicIncident struct {
+	IncidentID string `json:"incidentId"`
+	// Panic and Stack are only set in debug mode.
+	Panic string `json:"panic,omitempty"`
+	Stack string `json:"stack,omitempty"`
+}
+
+// WithPanicPolicy replaces the default PanicPolicy.
+func WithPanicPolicy(policy PanicPolicy) HandlerOption {
+	return func(h *ServiceHandler) {
+		h.panicPolicy = policy
+	}
+}
+
+func newIncidentID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// panicFailure applies the panic policy and returns the failure written to the client.
+func (h *ServiceHandler) panicFailure(r *http.Request, ps *panicStack) *Failure {
+	if h.panicPolicy.RepanicAbortHandler && ps.value == http.ErrAbortHandler {
+		panic(http.ErrAbortHandler)
+	}
+
+	h.metrics.countPanic(r.Method, h.routeLabel())
+	incident := &PanicIncident{IncidentID: newIncidentID()}
+	if h.panicPolicy.ErrorLog != nil {
+		h.panicPolicy.ErrorLog.Printf("panic incident %s: %s %s: %s\n%s", incident.IncidentID, r.Method,
+			h.routeOf(r).Path, ps.Panic, ps.Stack)
+	}
+
+	if h.loggerContextKey != nil {
+		logger, _ := r.Context().Value(h.loggerContextKey).(MethodCallLogger)
+		if logger != nil {
+			logger.Record("methodPanicIncident", incident.IncidentID)
+			logger.Record("methodPanic", ps.Panic)
+			logger.Record("methodPanicStack", ps.Stack)
+		}
+	}
+
+	if !h.panicPolicy.Debug {
+		return &Failure{MethodPanicFailure, http.StatusInternalServerError, "service method panicked", incident, nil}
+	}
+
+	incident.Panic = ps.Panic
+	incident.Stack = ps.Stack
+	return &Failure{MethodPanicFailure, http.StatusInternalServerError, "service method panicked", incident, ps}
+}
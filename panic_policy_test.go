@@ -0,0 +1,75 @@
+package kellyframework
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func panicPolicyFunction(ctx *ServiceMethodContext, arg *struct{ Abort bool }) (string, error) {
+	if arg.Abort {
+		panic(http.ErrAbortHandler)
+	}
+
+	panic("secret internals")
+}
+
+func TestPanicPolicy(t *testing.T) {
+	errorLog := &bytes.Buffer{}
+	handler, err := NewServiceHandlerWithOptions(panicPolicyFunction, WithLoggerContextKey(recordingLoggerKey{}),
+		WithPanicPolicy(PanicPolicy{ErrorLog: log.New(errorLog, "", 0), RepanicAbortHandler: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := recordingLogger{}
+	req := httptest.NewRequest("GET", "/panic", nil)
+	req = req.WithContext(context.WithValue(req.Context(), recordingLoggerKey{}, MethodCallLogger(logger)))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTPWithParams(recorder, req, nil)
+
+	var resp struct {
+		Data PanicIncident
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &resp); err != nil || recorder.Code != 500 {
+		t.Fatal("response is wrong:", recorder.Code, recorder.Body)
+	}
+
+	if resp.Data.IncidentID == "" || strings.Contains(recorder.Body.String(), "secret internals") ||
+		strings.Contains(recorder.Body.String(), "goroutine") {
+		t.Error("response leaks the panic:", recorder.Body)
+	}
+
+	if !strings.Contains(errorLog.String(), "panic incident "+resp.Data.IncidentID+": GET /panic: secret internals") ||
+		!strings.Contains(errorLog.String(), "goroutine") {
+		t.Error("error log is wrong:", errorLog)
+	}
+
+	if logger["methodPanicIncident"] != resp.Data.IncidentID || logger["methodPanic"] != "secret internals" ||
+		!strings.Contains(logger["methodPanicStack"], "goroutine") {
+		t.Error("method call log is wrong:", logger)
+	}
+
+	func() {
+		defer func() {
+			if p := recover(); p != http.ErrAbortHandler {
+				t.Error("http.ErrAbortHandler is not panicked again:", p)
+			}
+		}()
+
+		handler.ServeHTTPWithParams(httptest.NewRecorder(), httptest.NewRequest("GET", "/panic?Abort=true", nil), nil)
+	}()
+
+	WithPanicPolicy(PanicPolicy{Debug: true})(handler)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTPWithParams(recorder, httptest.NewRequest("GET", "/panic", nil), nil)
+	if !strings.Contains(recorder.Body.String(), "secret internals") ||
+		!strings.Contains(recorder.Body.String(), `"incidentId"`) {
+		t.Error("debug response is wrong:", recorder.Body)
+	}
+}
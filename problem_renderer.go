@@ -0,0 +1,91 @@
+package kellyframework
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+const problemContentType = "application/problem+json"
+
+// ProblemDetails is the RFC 7807 problem object. Extensions are marshaled as top level members beside the
+// standard ones.
+type ProblemDetails struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Extensions map[string]interface{}
+}
+
+func (p *ProblemDetails) MarshalJSON() ([]byte, error) {
+	members := make(map[string]interface{}, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		members[k] = v
+	}
+
+	members["type"] = p.Type
+	members["title"] = p.Title
+	members["status"] = p.Status
+	if p.Detail != "" {
+		members["detail"] = p.Detail
+	}
+
+	if p.Instance != "" {
+		members["instance"] = p.Instance
+	}
+
+	return json.Marshal(members)
+}
+
+// ProblemJSONRenderer writes results as is and writes every failure as 'application/problem+json'. When TypeBaseURI
+// is empty the problem type is 'about:blank' and the title is the status text, otherwise the type is TypeBaseURI
+// followed by the failure kind, e.g. 'https://example.com/problems/method-error'.
+type ProblemJSONRenderer struct {
+	TypeBaseURI string
+}
+
+var failureKindSlugs = map[FailureKind]string{
+	ArgumentParseFailure:      "argument-parse-failure",
+	ArgumentValidationFailure: "argument-validation-failure",
+	MethodErrorFailure:        "method-error",
+	MethodPanicFailure:        "method-panic",
+	ResponseEncodeFailure:     "response-encode-failure",
+	MiddlewareFailure:         "middleware-failure",
+}
+
+func (pr ProblemJSONRenderer) RenderResult(r *http.Request, result interface{}) *RenderedResponse {
+	return FormattedResponseRenderer{}.RenderResult(r, result)
+}
+
+func (pr ProblemJSONRenderer) RenderFailure(r *http.Request, f *Failure) *RenderedResponse {
+	problem := &ProblemDetails{
+		"about:blank",
+		http.StatusText(f.Status),
+		f.Status,
+		"",
+		// the query is left out, it may carry personal data and the problem is often logged or shown to users.
+		r.URL.EscapedPath(),
+		map[string]interface{}{"code": f.Msg},
+	}
+
+	if pr.TypeBaseURI != "" {
+		problem.Type = strings.TrimSuffix(pr.TypeBaseURI, "/") + "/" + failureKindSlugs[f.Kind]
+		problem.Title = f.Msg
+	}
+
+	if detail, ok := f.Data.(string); ok {
+		problem.Detail = detail
+	} else {
+		if f.Err != nil {
+			problem.Detail = f.Err.Error()
+		}
+
+		if f.Data != nil {
+			problem.Extensions["data"] = f.Data
+		}
+	}
+
+	return &RenderedResponse{f.Status, problemContentType, problem}
+}
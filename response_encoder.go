@@ -0,0 +1,218 @@
+package kellyframework
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ResponseEncoder writes v to w in its media type. It returns ErrNotEncodable if v can not be represented in the
+// media type, then the next acceptable media type is tried.
+type ResponseEncoder func(w io.Writer, v interface{}) error
+
+// ProtoMarshaler is implemented by protobuf messages which marshal themselves, e.g. the gogo/protobuf generated
+// ones.
+type ProtoMarshaler interface {
+	Marshal() ([]byte, error)
+}
+
+// ProtoMessage is implemented by the messages generated by golang/protobuf and google.golang.org/protobuf. They are
+// encoded by the marshal function given to WithProtoMarshal, usually proto.Marshal.
+type ProtoMessage interface {
+	ProtoMessage()
+}
+
+var ErrNotEncodable = errors.New("the value can not be encoded in the media type")
+
+var errNotAcceptable = errors.New("no acceptable media type")
+
+type responseEncoderRegistry struct {
+	mediaTypes []string
+	encoders   map[string]ResponseEncoder
+}
+
+type acceptRange struct {
+	mediaType string
+	quality   float64
+}
+
+type encoderOffer struct {
+	mediaType   string
+	contentType string
+	encoder     ResponseEncoder
+}
+
+func encodeJSON(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func protobufEncoder(marshal func(m ProtoMessage) ([]byte, error)) ResponseEncoder {
+	return func(w io.Writer, v interface{}) error {
+		var data []byte
+		var err error
+		if m, ok := v.(ProtoMarshaler); ok {
+			data, err = m.Marshal()
+		} else if m, ok := v.(ProtoMessage); ok && marshal != nil {
+			data, err = marshal(m)
+		} else {
+			return ErrNotEncodable
+		}
+
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(data)
+		return err
+	}
+}
+
+func defaultResponseEncoders() *responseEncoderRegistry {
+	registry := &responseEncoderRegistry{encoders: make(map[string]ResponseEncoder)}
+	registry.register("application/json", encodeJSON)
+	registry.register("application/msgpack", encodeMsgpack)
+	registry.register("application/x-msgpack", encodeMsgpack)
+	registry.register("application/x-protobuf", protobufEncoder(nil))
+	registry.register("application/protobuf", protobufEncoder(nil))
+	return registry
+}
+
+func (reg *responseEncoderRegistry) register(mediaType string, encoder ResponseEncoder) {
+	mediaType = strings.ToLower(mediaType)
+	if _, ok := reg.encoders[mediaType]; !ok {
+		reg.mediaTypes = append(reg.mediaTypes, mediaType)
+	}
+
+	reg.encoders[mediaType] = encoder
+}
+
+// WithResponseEncoder registers an encoder for the media type. For 'Accept: */*' the built-in JSON encoder is still
+// preferred, then the other ones in registering order.
+func WithResponseEncoder(mediaType string, encoder ResponseEncoder) HandlerOption {
+	return func(h *ServiceHandler) {
+		h.responseEncoders.register(mediaType, encoder)
+	}
+}
+
+// WithProtoMarshal makes the protobuf encoders marshal ProtoMessage values with marshal. The framework does not
+// depend on golang/protobuf, so it is passed in, e.g.:
+//
+//	WithProtoMarshal(func(m ProtoMessage) ([]byte, error) { return proto.Marshal(m.(proto.Message)) })
+func WithProtoMarshal(marshal func(m ProtoMessage) ([]byte, error)) HandlerOption {
+	return func(h *ServiceHandler) {
+		h.responseEncoders.register("application/x-protobuf", protobufEncoder(marshal))
+		h.responseEncoders.register("application/protobuf", protobufEncoder(marshal))
+	}
+}
+
+func parseAccept(header string) []acceptRange {
+	if strings.TrimSpace(header) == "" {
+		return []acceptRange{{"*/*", 1}}
+	}
+
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		if mediaType == "" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+
+		if quality > 0 {
+			ranges = append(ranges, acceptRange{mediaType, quality})
+		}
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].quality > ranges[j].quality
+	})
+
+	return ranges
+}
+
+func mediaRangeMatches(mediaRange string, mediaType string) bool {
+	if mediaRange == "*/*" || mediaRange == mediaType {
+		return true
+	}
+
+	return strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, mediaRange[:len(mediaRange)-1])
+}
+
+func isJSONMediaType(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// offers lists what the handler can write for resp in preference order. A JSON family content type chosen by the
+// renderer, e.g. 'application/problem+json', is kept when the JSON encoder is used.
+func (reg *responseEncoderRegistry) offers(resp *RenderedResponse) []*encoderOffer {
+	var offers []*encoderOffer
+	rendered := strings.ToLower(resp.ContentType)
+	if rendered != "" && isJSONMediaType(rendered) {
+		offers = append(offers, &encoderOffer{rendered, resp.ContentType, reg.encoders["application/json"]})
+	} else if encoder, ok := reg.encoders[rendered]; ok {
+		offers = append(offers, &encoderOffer{rendered, resp.ContentType, encoder})
+	}
+
+	for _, mediaType := range reg.mediaTypes {
+		contentType := mediaType
+		if mediaType == "application/json" && rendered != "" && isJSONMediaType(rendered) {
+			contentType = resp.ContentType
+		}
+
+		offers = append(offers, &encoderOffer{mediaType, contentType, reg.encoders[mediaType]})
+	}
+
+	return offers
+}
+
+// encode picks the encoder by the Accept header of the request and encodes the response body.
+func (reg *responseEncoderRegistry) encode(r *http.Request, resp *RenderedResponse) (string, []byte, error) {
+	offers := reg.offers(resp)
+	for _, ar := range parseAccept(r.Header.Get("Accept")) {
+		for _, offer := range offers {
+			if offer.encoder == nil || !mediaRangeMatches(ar.mediaType, offer.mediaType) {
+				continue
+			}
+
+			buf := &bytes.Buffer{}
+			err := offer.encoder(buf, resp.Body)
+			if err == ErrNotEncodable {
+				continue
+			} else if err != nil {
+				return "", nil, err
+			}
+
+			return offer.contentType, buf.Bytes(), nil
+		}
+	}
+
+	return "", nil, errNotAcceptable
+}
+
+// encodeJSONFallback is used for failures which the client accepts no media type of, it is better to tell the
+// client what is wrong in JSON than nothing.
+func (reg *responseEncoderRegistry) encodeJSONFallback(resp *RenderedResponse) (string, []byte, error) {
+	contentType := resp.ContentType
+	if contentType == "" || !isJSONMediaType(strings.ToLower(contentType)) {
+		contentType = "application/json"
+	}
+
+	buf := &bytes.Buffer{}
+	err := encodeJSON(buf, resp.Body)
+	return contentType, buf.Bytes(), err
+}
@@ -0,0 +1,98 @@
+package kellyframework
+
+import (
+	"net/http"
+
+	"golang.org/x/net/trace"
+)
+
+type FailureKind int
+
+const (
+	ArgumentParseFailure FailureKind = iota
+	ArgumentValidationFailure
+	MethodErrorFailure
+	MethodPanicFailure
+	ResponseEncodeFailure
+	MiddlewareFailure
+)
+
+// Failure describes a request which could not produce a normal result, whatever stage it failed at.
+type Failure struct {
+	Kind   FailureKind
+	Status int
+	Msg    string
+	Data   interface{}
+	// Err is the original error, it is nil for panics and for failures returned as *FormattedResponse.
+	Err error
+}
+
+// RenderedResponse is what a ResponseRenderer wants to be written to the client. The body is encoded in the media
+// type negotiated by the Accept header, a JSON family ContentType, e.g. 'application/problem+json', is kept when the
+// body is encoded as JSON.
+type RenderedResponse struct {
+	Status      int
+	ContentType string
+	Body        interface{}
+}
+
+// ResponseRenderer decides the shape of every response written by ServiceHandler, so that successful results
+// and failures can share one envelope.
+type ResponseRenderer interface {
+	RenderResult(r *http.Request, result interface{}) *RenderedResponse
+	RenderFailure(r *http.Request, f *Failure) *RenderedResponse
+}
+
+// FormattedResponseRenderer is the default renderer: results are written as is, and failures are written as
+// FormattedResponse.
+type FormattedResponseRenderer struct{}
+
+func (FormattedResponseRenderer) RenderResult(r *http.Request, result interface{}) *RenderedResponse {
+	if resp, ok := result.(*FormattedResponse); ok {
+		return &RenderedResponse{resp.Code, "", resp}
+	}
+
+	return &RenderedResponse{http.StatusOK, "", result}
+}
+
+func (FormattedResponseRenderer) RenderFailure(r *http.Request, f *Failure) *RenderedResponse {
+	return &RenderedResponse{f.Status, "", &FormattedResponse{f.Status, f.Msg, f.Data}}
+}
+
+// writeRenderedResponse encodes resp in the media type the client accepts and writes it, the response which is
+// really written is returned, it differs from resp when encoding fails.
+func (h *ServiceHandler) writeRenderedResponse(w http.ResponseWriter, r *http.Request, tr trace.Trace,
+	resp *RenderedResponse) *RenderedResponse {
+	contentType, body, err := h.responseEncoders.encode(r, resp)
+	if err == errNotAcceptable && resp.Status < 400 {
+		resp = h.renderer.RenderFailure(r, &Failure{ResponseEncodeFailure, http.StatusNotAcceptable, "not acceptable",
+			h.responseEncoders.mediaTypes, err})
+		contentType, body, err = h.responseEncoders.encodeJSONFallback(resp)
+	} else if err == errNotAcceptable {
+		contentType, body, err = h.responseEncoders.encodeJSONFallback(resp)
+	}
+
+	if err != nil {
+		resp = h.renderer.RenderFailure(r, &Failure{ResponseEncodeFailure, http.StatusInternalServerError,
+			"encode response failed", err.Error(), err})
+		contentType, body, err = h.responseEncoders.encodeJSONFallback(resp)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	tr.LazyPrintf("%d: %+v", resp.Status, resp.Body)
+	if resp.Status >= 400 {
+		tr.SetError()
+	}
+
+	// Prevents Internet Explorer from MIME-sniffing a response away from the declared content-type
+	w.Header().Set("x-content-type-options", "nosniff")
+	w.Header().Set("Content-Type", contentType)
+	if resp.Status != http.StatusOK {
+		w.WriteHeader(resp.Status)
+	}
+
+	w.Write(body)
+	return resp
+}
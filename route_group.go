@@ -0,0 +1,55 @@
+package kellyframework
+
+import (
+	"strings"
+)
+
+// RouteGroup shares a path prefix, handler options and middleware among routes, groups can be nested. A group is
+// registered by passing the result of Flatten to the router functions.
+type RouteGroup struct {
+	Prefix string
+	// BypassRequestBody and BypassResponseBody are copied to the routes of the group and its subgroups, so that the
+	// OpenAPI document, the API explorer and the generated clients see them. Set them instead of passing
+	// WithBypassRequestBody or WithBypassResponseBody in Options.
+	BypassRequestBody  bool
+	BypassResponseBody bool
+	// Options are applied after the options of the parent group and before the options of each Route.
+	Options []HandlerOption
+	// Middleware runs after the middleware of the parent group and before the middleware of each Route.
+	Middleware []Middleware
+	Routes     []*Route
+	Groups     []*RouteGroup
+}
+
+// Flatten returns the routes of the group and its subgroups with the prefixes, options and middleware of the groups
+// applied. The routes in the group are not modified.
+func (g *RouteGroup) Flatten() []*Route {
+	return g.flatten("", nil, false, false)
+}
+
+func (g *RouteGroup) flatten(prefix string, opts []HandlerOption, bypassRequestBody bool,
+	bypassResponseBody bool) []*Route {
+	prefix = strings.TrimSuffix(prefix+g.Prefix, "/")
+	bypassRequestBody = bypassRequestBody || g.BypassRequestBody
+	bypassResponseBody = bypassResponseBody || g.BypassResponseBody
+	opts = append(append([]HandlerOption{}, opts...), g.Options...)
+	if len(g.Middleware) > 0 {
+		opts = append(opts, WithMiddleware(g.Middleware...))
+	}
+
+	var routes []*Route
+	for _, rt := range g.Routes {
+		flattened := *rt
+		flattened.Path = prefix + rt.Path
+		flattened.BypassRequestBody = rt.BypassRequestBody || bypassRequestBody
+		flattened.BypassResponseBody = rt.BypassResponseBody || bypassResponseBody
+		flattened.Options = append(append([]HandlerOption{}, opts...), rt.Options...)
+		routes = append(routes, &flattened)
+	}
+
+	for _, group := range g.Groups {
+		routes = append(routes, group.flatten(prefix, opts, bypassRequestBody, bypassResponseBody)...)
+	}
+
+	return routes
+}
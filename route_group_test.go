@@ -0,0 +1,89 @@
+package kellyframework
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRouteGroup(t *testing.T) {
+	group := &RouteGroup{
+		Prefix:     "/api/",
+		Middleware: []Middleware{traceMiddleware("api")},
+		Routes:     []*Route{{Method: "GET", Path: "/hello/:Name", Function: middlewareFunction}},
+		Groups: []*RouteGroup{
+			{
+				Prefix:     "/admin",
+				Options:    []HandlerOption{WithMaxBodySize(32)},
+				Middleware: []Middleware{traceMiddleware("admin")},
+				Routes: []*Route{
+					{Method: "POST", Path: "/hello", Function: middlewareFunction,
+						Middleware: []Middleware{traceMiddleware("route")}},
+				},
+			},
+		},
+	}
+
+	routes := group.Flatten()
+	if len(routes) != 2 || routes[0].Path != "/api/hello/:Name" || routes[1].Path != "/api/admin/hello" ||
+		group.Groups[0].Routes[0].Path != "/hello" {
+		t.Fatal("flattened routes are wrong:", routes)
+	}
+
+	router, err := NewHTTPRouter(routes, WithMiddleware(traceMiddleware("router")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/hello/kelly", nil))
+	if !strings.Contains(recorder.Body.String(), "router(/api/hello/:Name) api(/api/hello/:Name) method(kelly)") {
+		t.Error("group middleware is wrong:", recorder.Body)
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("POST", "/api/admin/hello", strings.NewReader(`{"Name":"kelly"}`)))
+	if !strings.Contains(recorder.Body.String(),
+		"router(/api/admin/hello) api(/api/admin/hello) admin(/api/admin/hello) route(/api/admin/hello) method(") {
+		t.Error("nested group middleware is wrong:", recorder.Body)
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("POST", "/api/admin/hello",
+		strings.NewReader(`{"Name":"`+strings.Repeat("kelly", 8)+`"}`)))
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Error("nested group options are not applied:", recorder.Code)
+	}
+}
+
+func TestRouteGroupBodyBypass(t *testing.T) {
+	group := &RouteGroup{
+		BypassRequestBody: true,
+		Routes: []*Route{
+			{Method: "POST", Path: "/upload", Function: middlewareFunction},
+			{Method: "POST", Path: "/download", Function: middlewareFunction, BypassResponseBody: true},
+		},
+		Groups: []*RouteGroup{
+			{Prefix: "/v2", BypassResponseBody: true,
+				Routes: []*Route{{Method: "POST", Path: "/upload", Function: middlewareFunction}}},
+		},
+	}
+
+	routes := group.Flatten()
+	if !routes[0].BypassRequestBody || routes[0].BypassResponseBody || !routes[1].BypassRequestBody ||
+		!routes[1].BypassResponseBody || !routes[2].BypassRequestBody || !routes[2].BypassResponseBody {
+		t.Fatal("group body bypassing is not copied to the routes:", routes[0], routes[1], routes[2])
+	}
+
+	router, err := NewHTTPRouter(routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("POST", "/download", strings.NewReader(`{"Name":"kelly"}`)))
+	if recorder.Body.Len() != 0 {
+		t.Error("route body bypassing is overridden by the group:", recorder.Body)
+	}
+}
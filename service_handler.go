@@ -1,6 +1,7 @@
 package kellyframework
 
 import (
+	"errors"
 	"reflect"
 	"fmt"
 	"net/http"
@@ -24,18 +25,45 @@ type ServiceMethodContext struct {
 	RequestBodyReader  io.ReadCloser
 	ResponseHeader     http.Header
 	ResponseBodyWriter io.Writer
+	// SpanContext is the span of the method call when the handler has a Tracer, Context carries it too.
+	SpanContext SpanContext
 }
 
 type MethodCallLogger interface {
 	Record(field string, value string)
 }
 
+// MethodCallValueLogger is implemented by loggers which keep the types of the values, the handler uses it for the
+// numeric and time fields when it is available.
+type MethodCallValueLogger interface {
+	RecordValue(field string, value interface{})
+}
+
 type ServiceHandler struct {
 	loggerContextKey   interface{}
 	method             *serviceMethod
 	validator          *validator.Validate
+	translator         *validationTranslator
 	bypassRequestBody  bool
 	bypassResponseBody bool
+	renderer           ResponseRenderer
+	timeout            time.Duration
+	bodyDecoders       map[string]BodyDecoder
+	responseEncoders   *responseEncoderRegistry
+	multipartMaxMemory int64
+	maxFileSize        int64
+	maxBodySize        int64
+	jsonDecodeOptions  JSONDecodeOptions
+	binding            *argumentBinding
+	sourcePrecedence   []string
+	conflictPolicy     ConflictPolicy
+	middleware         []Middleware
+	route              *Route
+	interceptors       []Interceptor
+	panicPolicy        PanicPolicy
+	errorReporters     []ErrorReporter
+	tracer             *Tracer
+	metrics            *Metrics
 }
 
 type FormattedResponse struct {
@@ -47,17 +75,22 @@ type FormattedResponse struct {
 type serviceMethod struct {
 	value   reflect.Value
 	argType reflect.Type
+	// call is set for methods registered with the generic API, it calls the method without reflection.
+	call func(ctx *ServiceMethodContext, arg interface{}) (interface{}, error)
 }
 
 type panicStack struct {
 	Panic string `json:"panic"`
 	Stack string `json:"stack"`
+	value interface{}
 }
 
 const traceFamily = "kellyframework.ServiceHandler"
 
 var formDecoder = schema.NewDecoder()
 
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
 func init() {
 	formDecoder.IgnoreUnknownKeys(true)
 }
@@ -79,66 +112,86 @@ func checkServiceMethodPrototype(methodType reflect.Type) error {
 		return fmt.Errorf("the second argument should be a struct pointer")
 	}
 
-	if methodType.NumOut() != 1 {
-		return fmt.Errorf("the service method should have only one return value")
+	switch methodType.NumOut() {
+	case 1:
+	case 2:
+		if methodType.Out(1) != errorType {
+			return fmt.Errorf("the second return value should be type error")
+		}
+	default:
+		return fmt.Errorf("the service method should have one or two return values")
 	}
 
 	return nil
 }
 
+// NewServiceHandler is kept for compatibility, new knobs are only added as HandlerOption, see
+// NewServiceHandlerWithOptions.
 func NewServiceHandler(method interface{}, loggerContextKey interface{}, bypassRequestBody bool,
-	bypassResponseBody bool) (h *ServiceHandler, err error) {
-	// the method prototype like this: 'func(*ServiceMethodContext, *struct) (anything)'
+	bypassResponseBody bool, opts ...HandlerOption) (h *ServiceHandler, err error) {
+	return NewServiceHandlerWithOptions(method, append([]HandlerOption{
+		WithLoggerContextKey(loggerContextKey),
+		WithBypassRequestBody(bypassRequestBody),
+		WithBypassResponseBody(bypassResponseBody),
+	}, opts...)...)
+}
+
+func NewServiceHandlerWithOptions(method interface{}, opts ...HandlerOption) (h *ServiceHandler, err error) {
+	// the method prototype like this: 'func(*ServiceMethodContext, *struct) (anything)' or
+	// 'func(*ServiceMethodContext, *struct) (anything, error)'.
 	methodType := reflect.TypeOf(method)
 	err = checkServiceMethodPrototype(methodType)
 	if err != nil {
 		return
 	}
 
+	binding, err := newArgumentBinding(methodType.In(1).Elem())
+	if err != nil {
+		return
+	}
+
+	v, translator := sharedDefaultValidator()
 	h = &ServiceHandler{
-		loggerContextKey,
-		&serviceMethod{
-			reflect.ValueOf(method),
-			methodType.In(1),
-		},
-		validator.New(),
-		bypassRequestBody,
-		bypassResponseBody,
+		method:             &serviceMethod{value: reflect.ValueOf(method), argType: methodType.In(1)},
+		validator:          v,
+		translator:         translator,
+		renderer:           FormattedResponseRenderer{},
+		responseEncoders:   defaultResponseEncoders(),
+		multipartMaxMemory: defaultMultipartMaxMemory,
+		binding:            binding,
+		sourcePrecedence:   defaultSourcePrecedence,
+		conflictPolicy:     OverrideByPrecedence,
 	}
+	h.bodyDecoders = h.defaultBodyDecoders()
 
-	return
-}
+	for _, opt := range opts {
+		opt(h)
+	}
 
-func setResponseHeader(w http.ResponseWriter) {
-	// Prevents Internet Explorer from MIME-sniffing a response away from the declared content-type
-	w.Header().Set("x-content-type-options", "nosniff")
-	w.Header().Set("Content-Type", "application/json")
-}
+	err = checkSourcePrecedence(h.sourcePrecedence)
+	if err != nil {
+		h = nil
+	}
 
-func writeResponse(w http.ResponseWriter, tr trace.Trace, data interface{}) {
-	tr.LazyPrintf("%+v", data)
-	setResponseHeader(w)
-	json.NewEncoder(w).Encode(data)
+	return
 }
 
-func writeFormattedResponse(w http.ResponseWriter, tr trace.Trace, resp *FormattedResponse) {
-	tr.LazyPrintf("%s: %+v", resp.Msg, resp.Data)
-	if resp.Code >= 400 {
-		tr.SetError()
+func newPanicStack(panicInfo interface{}) *panicStack {
+	return &panicStack{
+		fmt.Sprintf("%s", panicInfo),
+		fmt.Sprintf("%s", debug.Stack()),
+		panicInfo,
 	}
-	
-	setResponseHeader(w)
-	w.WriteHeader(resp.Code)
-	json.NewEncoder(w).Encode(resp)
+}
+
+func (ps *panicStack) Error() string {
+	return "panic: " + ps.Panic
 }
 
 func doServiceMethodCall(method *serviceMethod, in []reflect.Value) (out []reflect.Value, ps *panicStack) {
 	defer func() {
 		if panicInfo := recover(); panicInfo != nil {
-			ps = &panicStack{
-				fmt.Sprintf("%s", panicInfo),
-				fmt.Sprintf("%s", debug.Stack()),
-			}
+			ps = newPanicStack(panicInfo)
 		}
 	}()
 
@@ -147,38 +200,66 @@ func doServiceMethodCall(method *serviceMethod, in []reflect.Value) (out []refle
 }
 
 func (h *ServiceHandler) parseArgument(r *http.Request, params httprouter.Params, arg interface{}) error {
-	// query string has lowest priority.
-	err := r.ParseForm()
-	if err != nil {
-		return err
+	query := r.URL.Query()
+	paramValues := url.Values{}
+	for _, param := range params {
+		paramValues.Set(param.Key, param.Value)
 	}
 
-	err = formDecoder.Decode(arg, r.Form)
-	if err != nil {
-		return err
-	}
+	// decode the sources from the lowest precedence to the highest, by default query string < body < params.
+	conflicts := sourceConflicts{}
+	for _, source := range h.sourcePrecedence {
+		var err error
+		switch source {
+		case SourceQuery:
+			values := h.binding.filter(query, SourceQuery)
+			conflicts.add(SourceQuery, h.binding.settableFieldNames(valueKeys(values), false))
+			err = formDecoder.Decode(arg, values)
+		case SourceBody:
+			if h.bypassRequestBody {
+				continue
+			}
+
+			if h.conflictPolicy == RejectConflicts {
+				var keys []string
+				keys, err = peekJSONBodyKeys(r)
+				if err != nil {
+					break
+				}
+
+				conflicts.add(SourceBody, h.binding.settableFieldNames(keys, true))
+			}
+
+			err = h.decodeBody(r, arg)
+			conflicts.add(SourceBody, h.binding.settableFieldNames(formBodyKeys(r), false))
+		case SourcePath:
+			if len(paramValues) == 0 {
+				continue
+			}
+
+			values := h.binding.filter(paramValues, SourcePath)
+			conflicts.add(SourcePath, h.binding.settableFieldNames(valueKeys(values), false))
+			err = formDecoder.Decode(arg, values)
+		}
 
-	// json content is prior to query string.
-	if !h.bypassRequestBody && r.Header.Get("Content-Type") == "application/json" {
-		err := json.NewDecoder(r.Body).Decode(arg)
 		if err != nil {
 			return err
 		}
 	}
 
-	// params is prior to json content.
-	if params != nil {
-		paramValues := url.Values{}
-		for _, param := range params {
-			paramValues.Set(param.Key, param.Value)
-		}
-
-		err = formDecoder.Decode(arg, paramValues)
+	if h.conflictPolicy == RejectConflicts {
+		err := conflicts.err()
 		if err != nil {
 			return err
 		}
 	}
 
+	// fields with explicit sources are only set by their own sources.
+	err := h.binding.apply(reflect.ValueOf(arg).Elem(), r, query, params)
+	if err != nil {
+		return err
+	}
+
 	err = h.validator.Struct(arg)
 	if err != nil {
 		return err
@@ -187,69 +268,180 @@ func (h *ServiceHandler) parseArgument(r *http.Request, params httprouter.Params
 	return nil
 }
 
+func (h *ServiceHandler) parseFailure(r *http.Request, err error) *Failure {
+	failure := &Failure{ArgumentParseFailure, 400, "parse argument failed", err.Error(), err}
+	var se ServiceMethodError
+	if errs, ok := err.(validator.ValidationErrors); ok {
+		failure.Kind = ArgumentValidationFailure
+		h.metrics.countValidationFailure(r.Method, h.routeLabel())
+		failure.Data = fieldValidationErrors(h.method.argType.Elem(), errs, h.translator.translator(r))
+	} else if errors.As(err, &se) {
+		resp := errorToFormattedResponse(err)
+		failure.Status, failure.Msg, failure.Data = resp.Code, resp.Msg, resp.Data
+	}
+
+	return failure
+}
+
 func (h *ServiceHandler) ServeHTTP(respWriter http.ResponseWriter, req *http.Request) {
 	h.ServeHTTPWithParams(respWriter, req, nil)
 }
 
 func (h *ServiceHandler) ServeHTTPWithParams(rw http.ResponseWriter, r *http.Request, params httprouter.Params) {
-	tracer := trace.New(traceFamily, r.URL.Path)
+	pattern := h.routeLabel()
+	tracer := trace.New(traceFamily, r.Method+" "+h.routeOf(r).Path)
 	defer tracer.Finish()
 
+	var span *Span
+	if h.tracer != nil {
+		var ctx context.Context
+		ctx, span = h.tracer.Start(ContextWithSpanContext(r.Context(), ExtractTraceContext(r.Header)),
+			r.Method+" "+pattern, SpanKindServer)
+		span.SetAttribute("http.request.method", r.Method)
+		if h.route != nil {
+			span.SetAttribute("http.route", pattern)
+		}
+
+		span.SetAttribute("url.path", r.URL.Path)
+		span.SetAttribute("client.address", r.RemoteAddr)
+		r = r.WithContext(ctx)
+	}
+
+	if h.tracer != nil || h.metrics != nil {
+		sw := &statusResponseWriter{ResponseWriter: rw, status: http.StatusOK}
+		h.metrics.beginRequest(r.Method, pattern)
+		defer func() {
+			h.metrics.endRequest(r.Method, pattern, sw.status)
+			span.SetAttribute("http.response.status_code", sw.status)
+			if sw.status >= http.StatusInternalServerError {
+				span.SetStatus(SpanStatusError, http.StatusText(sw.status))
+			}
+
+			span.End()
+		}()
+
+		rw = sw
+	}
+
+	if h.timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
+	if h.maxBodySize > 0 {
+		if r.ContentLength > h.maxBodySize {
+			err := NewServiceMethodError(http.StatusRequestEntityTooLarge, "request body too large", nil)
+			h.writeRenderedResponse(rw, r, tracer, h.renderer.RenderFailure(r, h.parseFailure(r, err)))
+			return
+		}
+
+		r.Body = http.MaxBytesReader(rw, r.Body, h.maxBodySize)
+	}
+
+	methodContext := &ServiceMethodContext{
+		r.Context(),
+		r.RemoteAddr,
+		r.Header,
+		r.Body,
+		rw.Header(),
+		rw,
+		SpanContextFromContext(r.Context()),
+	}
+	err := h.serveMiddleware(&MiddlewareContext{methodContext, r, params, h.routeOf(r)},
+		func(c *MiddlewareContext) error {
+			h.serveServiceMethod(rw, c.Request.WithContext(c.Context), c.Params, tracer, c.ServiceMethodContext)
+			return nil
+		})
+	if err != nil {
+		resp := errorToFormattedResponse(err)
+		h.writeRenderedResponse(rw, r, tracer,
+			h.renderer.RenderFailure(r, &Failure{MiddlewareFailure, resp.Code, resp.Msg, resp.Data, err}))
+	}
+}
+
+func (h *ServiceHandler) serveServiceMethod(rw http.ResponseWriter, r *http.Request, params httprouter.Params,
+	tracer trace.Trace, methodContext *ServiceMethodContext) {
 	// extract arguments.
 	arg := reflect.New(h.method.argType.Elem())
+	defer func() {
+		// r is a copy of the request given to net/http, which only cleans up the multipart form of the original.
+		if r.MultipartForm != nil {
+			r.MultipartForm.RemoveAll()
+		}
+	}()
+
+	_, phase := h.startPhase(r.Context(), r, parsePhase)
 	err := h.parseArgument(r, params, arg.Interface())
+	phase.end(err)
 	if err != nil {
-		writeFormattedResponse(rw, tracer, &FormattedResponse{400, "parse argument failed", err.Error()})
+		_, phase = h.startPhase(r.Context(), r, encodePhase)
+		h.writeRenderedResponse(rw, r, tracer, h.renderer.RenderFailure(r, h.parseFailure(r, err)))
+		phase.end(nil)
 		return
 	}
 
 	// do method call.
 	beginTime := time.Now()
-	out, methodPanic := doServiceMethodCall(h.method, []reflect.Value{
-		reflect.ValueOf(&ServiceMethodContext{
-			r.Context(),
-			r.RemoteAddr,
-			r.Header,
-			r.Body,
-			rw.Header(),
-			rw,
-		}),
-		arg,
-	})
+	ctx, phase := h.startPhase(methodContext.Context, r, callPhase)
+	if phase.span != nil {
+		methodContext.Context = ctx
+		methodContext.SpanContext = phase.span.SpanContext
+	}
+
+	inv := &Invocation{methodContext, h.routeOf(r), arg.Interface()}
+	result, err := h.invoke(inv)
 	duration := time.Now().Sub(beginTime)
+	phase.end(err)
 
 	// write returned value or error to response.
-	if methodPanic == nil && len(out) != 1 {
-		// the method prototype have more than one return value, it is forbidden.
-		panic(fmt.Sprintf("return values error: %+v", out))
-	}
-
-	var respData interface{}
-	if methodPanic != nil {
-		respData = &FormattedResponse{500, "service method panicked", methodPanic}
-		writeFormattedResponse(rw, tracer, respData.(*FormattedResponse))
+	var rendered *RenderedResponse
+	var failure *Failure
+	var methodPanic *panicStack
+	if errors.As(err, &methodPanic) {
+		failure = h.panicFailure(r, methodPanic)
 	} else {
-		methodReturn := out[0].Interface()
-		ok := false
-		if respData, ok = methodReturn.(*FormattedResponse); ok {
-			if respData.(*FormattedResponse) != nil {
-				writeFormattedResponse(rw, tracer, respData.(*FormattedResponse))
+		methodReturn := result
+		if err != nil {
+			// the error return value takes precedence over the result.
+			methodReturn = err
+		}
+
+		if resp, ok := methodReturn.(*FormattedResponse); ok {
+			if resp != nil && resp.Code >= 400 {
+				failure = &Failure{MethodErrorFailure, resp.Code, resp.Msg, resp.Data, nil}
+			} else if resp != nil {
+				rendered = h.renderer.RenderResult(r, resp)
 			}
-		} else if err, ok = methodReturn.(error); ok {
-			respData = &FormattedResponse{500, "service method error", err.Error()}
-			writeFormattedResponse(rw, tracer, respData.(*FormattedResponse))
+		} else if err, ok := methodReturn.(error); ok {
+			resp := errorToFormattedResponse(err)
+			failure = &Failure{MethodErrorFailure, resp.Code, resp.Msg, resp.Data, err}
 		} else if !h.bypassResponseBody {
 			// write to response body as JSON encoded string
-			respData = methodReturn
-			writeResponse(rw, tracer, respData)
+			rendered = h.renderer.RenderResult(r, methodReturn)
 		}
 	}
 
+	if failure != nil {
+		rendered = h.renderer.RenderFailure(r, failure)
+	}
+
+	var respData interface{}
+	if rendered != nil {
+		_, phase = h.startPhase(r.Context(), r, encodePhase)
+		respData = h.writeRenderedResponse(rw, r, tracer, rendered).Body
+		phase.end(nil)
+	}
+
+	if failure != nil && len(h.errorReporters) > 0 {
+		h.reportFailure(r, inv, failure, methodPanic)
+	}
+
 	// record some thing if logger existed.
 	if h.loggerContextKey != nil {
-		logger := r.Context().Value(h.loggerContextKey).(MethodCallLogger)
+		logger, _ := r.Context().Value(h.loggerContextKey).(MethodCallLogger)
 		if logger != nil {
-			marshaledArgs, err := json.Marshal(arg.Interface())
+			marshaledArgs, err := json.Marshal(inv.Argument)
 			if err != nil {
 				panic(err)
 			}
@@ -261,8 +453,13 @@ func (h *ServiceHandler) ServeHTTPWithParams(rw http.ResponseWriter, r *http.Req
 
 			logger.Record("methodCallArgument", string(marshaledArgs))
 			logger.Record("methodCallResponseData", string(marshaledData))
-			logger.Record("methodCallBeginTime", beginTime.Format("2006-01-02 03:04:05.999999999"))
-			logger.Record("methodCallDuration", strconv.FormatFloat(duration.Seconds(), 'f', -1, 64))
+			if valueLogger, ok := logger.(MethodCallValueLogger); ok {
+				valueLogger.RecordValue("methodCallBeginTime", beginTime)
+				valueLogger.RecordValue("methodCallDuration", duration.Seconds())
+			} else {
+				logger.Record("methodCallBeginTime", beginTime.Format(legacyTimeLayout))
+				logger.Record("methodCallDuration", strconv.FormatFloat(duration.Seconds(), 'f', -1, 64))
+			}
 		}
 	}
 }
@@ -1,12 +1,18 @@
 package kellyframework
 
 import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
 	"testing"
 	"strings"
 	"fmt"
+	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"time"
 	"github.com/julienschmidt/httprouter"
+	"gopkg.in/go-playground/validator.v9"
 )
 
 type empty struct {
@@ -26,6 +32,10 @@ func (e *empty) errorResponseMethod(*ServiceMethodContext, *empty) *FormattedRes
 	return &FormattedResponse{403, "forbidden", nil}
 }
 
+func (e *empty) wrappedServiceErrorMethod(*ServiceMethodContext, *empty) error {
+	return fmt.Errorf("wrapped: %w", NewServiceMethodError(404, "user not found", map[string]string{"name": "test"}))
+}
+
 func (e *empty) panicMethod(*ServiceMethodContext, *empty) interface{} {
 	panic("expected panic")
 	return nil
@@ -35,6 +45,14 @@ func emptyFunction(*ServiceMethodContext, *empty) *struct{ A int } {
 	return &struct{ A int }{1}
 }
 
+func twoReturnValuesFunction(_ *ServiceMethodContext, arg *validatorEnabled) (*struct{ A int }, error) {
+	if arg.A < 0 {
+		return nil, fmt.Errorf("expected error")
+	}
+
+	return &struct{ A int }{arg.A}, nil
+}
+
 func validatorEnabledFunction(*ServiceMethodContext, *validatorEnabled) error {
 	return nil
 }
@@ -76,12 +94,24 @@ func TestServiceHandlerCheckServiceMethodPrototype(t *testing.T) {
 		}
 	})
 
+	t.Run("three return values", func(t *testing.T) {
+		if err := checkServiceMethodPrototype(reflect.TypeOf(func(*ServiceMethodContext, *struct{}) (int, int, error) { return 0, 0, nil })); err == nil {
+			t.Error()
+		}
+	})
+
 	t.Run("normal function", func(t *testing.T) {
 		if err := checkServiceMethodPrototype(reflect.TypeOf(emptyFunction)); err != nil {
 			t.Error()
 		}
 	})
 
+	t.Run("normal two return values function", func(t *testing.T) {
+		if err := checkServiceMethodPrototype(reflect.TypeOf(twoReturnValuesFunction)); err != nil {
+			t.Error()
+		}
+	})
+
 	t.Run("normal object method", func(t *testing.T) {
 		if err := checkServiceMethodPrototype(reflect.TypeOf(emptyFunction)); err != nil {
 			t.Error()
@@ -101,6 +131,8 @@ func TestServiceHandlerServeHTTP(t *testing.T) {
 	h3, _ := NewServiceHandler(e.errorResponseMethod, nil, false, false)
 	h4, _ := NewServiceHandler(e.panicMethod, nil, false, false)
 	h5, _ := NewServiceHandler(validatorEnabledFunction, nil, false, false)
+	h6, _ := NewServiceHandler(twoReturnValuesFunction, nil, false, false)
+	h7, _ := NewServiceHandler(e.wrappedServiceErrorMethod, nil, false, false)
 
 	emptyFunctionNormalArguments := httptest.NewRequest("POST", "/emptyFunction", strings.NewReader("{}"))
 	emptyFunctionNormalArguments.Header.Add("content-type", "application/json")
@@ -200,4 +232,651 @@ func TestServiceHandlerServeHTTP(t *testing.T) {
 			t.Error("code is not 400, body:", recorder.Body)
 		}
 	})
+
+	twoReturnValuesFunctionNormalArguments := httptest.NewRequest("POST", "/twoReturnValuesFunction", strings.NewReader("{\"A\": 1}"))
+	twoReturnValuesFunctionNormalArguments.Header.Add("content-type", "application/json")
+	t.Run("two return values normal arguments", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		h6.ServeHTTP(recorder, twoReturnValuesFunctionNormalArguments)
+		if recorder.Code != 200 || strings.TrimSpace(recorder.Body.String()) != "{\"A\":1}" {
+			t.Error("code is not 200 or body is wrong, body:", recorder.Body)
+		}
+	})
+
+	twoReturnValuesFunctionErrorArguments := httptest.NewRequest("POST", "/twoReturnValuesFunction", strings.NewReader("{\"A\": -1}"))
+	twoReturnValuesFunctionErrorArguments.Header.Add("content-type", "application/json")
+	t.Run("two return values error", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		h6.ServeHTTP(recorder, twoReturnValuesFunctionErrorArguments)
+		if recorder.Code != 500 {
+			t.Error("code is not 500, body:", recorder.Body)
+		}
+	})
+
+	wrappedServiceErrorMethodNormalArguments := httptest.NewRequest("POST", "/wrappedServiceErrorMethod", strings.NewReader("{}"))
+	wrappedServiceErrorMethodNormalArguments.Header.Add("content-type", "application/json")
+	t.Run("wrapped service method error", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		h7.ServeHTTP(recorder, wrappedServiceErrorMethodNormalArguments)
+		expected := "{\"code\":404,\"msg\":\"user not found\",\"data\":{\"name\":\"test\"}}"
+		if recorder.Code != 404 || strings.TrimSpace(recorder.Body.String()) != expected {
+			t.Error("code is not 404 or body is wrong, body:", recorder.Body)
+		}
+	})
+}
+
+type envelopeRenderer struct{}
+
+type envelope struct {
+	Success bool        `json:"success"`
+	Result  interface{} `json:"result"`
+	Error   interface{} `json:"error"`
+}
+
+func (envelopeRenderer) RenderResult(r *http.Request, result interface{}) *RenderedResponse {
+	return &RenderedResponse{200, "", &envelope{true, result, nil}}
+}
+
+func (envelopeRenderer) RenderFailure(r *http.Request, f *Failure) *RenderedResponse {
+	return &RenderedResponse{f.Status, "", &envelope{false, nil, f.Msg}}
+}
+
+func TestServiceHandlerResponseRenderer(t *testing.T) {
+	h1, _ := NewServiceHandler(emptyFunction, nil, false, false, WithResponseRenderer(envelopeRenderer{}))
+	h2, _ := NewServiceHandler(e.errorMethod, nil, false, false, WithResponseRenderer(envelopeRenderer{}))
+	h3, _ := NewServiceHandler(validatorEnabledFunction, nil, false, false, WithResponseRenderer(envelopeRenderer{}))
+
+	t.Run("result", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		h1.ServeHTTP(recorder, httptest.NewRequest("GET", "/emptyFunction", nil))
+		expected := "{\"success\":true,\"result\":{\"A\":1},\"error\":null}"
+		if recorder.Code != 200 || strings.TrimSpace(recorder.Body.String()) != expected {
+			t.Error("code is not 200 or body is wrong, body:", recorder.Body)
+		}
+	})
+
+	t.Run("method error", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		h2.ServeHTTP(recorder, httptest.NewRequest("GET", "/errorMethod", nil))
+		expected := "{\"success\":false,\"result\":null,\"error\":\"service method error\"}"
+		if recorder.Code != 500 || strings.TrimSpace(recorder.Body.String()) != expected {
+			t.Error("code is not 500 or body is wrong, body:", recorder.Body)
+		}
+	})
+
+	t.Run("validation failure", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		h3.ServeHTTP(recorder, httptest.NewRequest("GET", "/validatorEnabledFunction", nil))
+		expected := "{\"success\":false,\"result\":null,\"error\":\"parse argument failed\"}"
+		if recorder.Code != 400 || strings.TrimSpace(recorder.Body.String()) != expected {
+			t.Error("code is not 400 or body is wrong, body:", recorder.Body)
+		}
+	})
+}
+
+func TestServiceHandlerProblemJSONRenderer(t *testing.T) {
+	h1, _ := NewServiceHandler(e.wrappedServiceErrorMethod, nil, false, false, WithResponseRenderer(ProblemJSONRenderer{}))
+	h2, _ := NewServiceHandler(e.errorMethod, nil, false, false,
+		WithResponseRenderer(ProblemJSONRenderer{"https://example.com/problems/"}))
+
+	t.Run("about blank", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		h1.ServeHTTP(recorder, httptest.NewRequest("GET", "/wrappedServiceErrorMethod?a=1", nil))
+		expected := "{\"code\":\"user not found\",\"data\":{\"name\":\"test\"},\"detail\":\"wrapped: 404 user not found\"," +
+			"\"instance\":\"/wrappedServiceErrorMethod\",\"status\":404,\"title\":\"Not Found\",\"type\":\"about:blank\"}"
+		if recorder.Code != 404 || recorder.Header().Get("Content-Type") != "application/problem+json" ||
+			strings.TrimSpace(recorder.Body.String()) != expected {
+			t.Error("code is not 404 or body is wrong, body:", recorder.Body)
+		}
+	})
+
+	t.Run("typed", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		h2.ServeHTTP(recorder, httptest.NewRequest("GET", "/errorMethod", nil))
+		expected := "{\"code\":\"service method error\",\"detail\":\"expected error\",\"instance\":\"/errorMethod\"," +
+			"\"status\":500,\"title\":\"service method error\",\"type\":\"https://example.com/problems/method-error\"}"
+		if recorder.Code != 500 || strings.TrimSpace(recorder.Body.String()) != expected {
+			t.Error("code is not 500 or body is wrong, body:", recorder.Body)
+		}
+	})
+}
+
+type validatedItem struct {
+	Name string `json:"name" validate:"required"`
+}
+
+type nestedValidated struct {
+	Age   int              `json:"age" validate:"max=140"`
+	Items []*validatedItem `json:"items" validate:"dive"`
+}
+
+func nestedValidatedFunction(*ServiceMethodContext, *nestedValidated) error {
+	return nil
+}
+
+func TestServiceHandlerValidationErrors(t *testing.T) {
+	h, _ := NewServiceHandler(nestedValidatedFunction, nil, false, false)
+
+	t.Run("english", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/nestedValidatedFunction", strings.NewReader("{\"age\":150,\"items\":[{}]}"))
+		r.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, r)
+		expected := "{\"code\":400,\"msg\":\"parse argument failed\",\"data\":[" +
+			"{\"field\":\"age\",\"tag\":\"max\",\"param\":\"140\",\"message\":\"age must be 140 or less\"}," +
+			"{\"field\":\"items[0].name\",\"tag\":\"required\",\"param\":\"\",\"message\":\"name is a required field\"}]}"
+		if recorder.Code != 400 || strings.TrimSpace(recorder.Body.String()) != expected {
+			t.Error("code is not 400 or body is wrong, body:", recorder.Body)
+		}
+	})
+
+	t.Run("chinese", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/nestedValidatedFunction", strings.NewReader("{\"items\":[{}]}"))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Accept-Language", "en;q=0.5, zh-CN")
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, r)
+		expected := "{\"code\":400,\"msg\":\"parse argument failed\",\"data\":[" +
+			"{\"field\":\"items[0].name\",\"tag\":\"required\",\"param\":\"\",\"message\":\"name为必填字段\"}]}"
+		if recorder.Code != 400 || strings.TrimSpace(recorder.Body.String()) != expected {
+			t.Error("code is not 400 or body is wrong, body:", recorder.Body)
+		}
+	})
+}
+
+type customValidated struct {
+	Phone string `json:"phone" validate:"phone_cn"`
+}
+
+func customValidatedFunction(*ServiceMethodContext, *customValidated) error {
+	return nil
+}
+
+func TestServiceHandlerSharedValidator(t *testing.T) {
+	v := NewValidator()
+	v.RegisterValidation("phone_cn", func(fl validator.FieldLevel) bool {
+		return len(fl.Field().String()) == 11 && strings.HasPrefix(fl.Field().String(), "1")
+	})
+
+	router := httprouter.New()
+	err := RegisterFunctionsToHTTPRouter(router, nil, []*Route{
+		{Method: "GET", Path: "/a", Function: customValidatedFunction},
+		{Method: "GET", Path: "/b", Function: customValidatedFunction},
+	}, WithValidator(v))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/a", "/b"} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("GET", path+"?phone=13800138000", nil))
+		if recorder.Code != 200 {
+			t.Error("code is not 200, body:", recorder.Body)
+		}
+
+		recorder = httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("GET", path+"?phone=123", nil))
+		if recorder.Code != 400 || !strings.Contains(recorder.Body.String(), "\"tag\":\"phone_cn\"") {
+			t.Error("code is not 400 or body is wrong, body:", recorder.Body)
+		}
+	}
+}
+
+func slowFunction(ctx *ServiceMethodContext, _ *empty) error {
+	<-ctx.Context.Done()
+	return ctx.Context.Err()
+}
+
+func TestServiceHandlerOptions(t *testing.T) {
+	t.Run("timeout", func(t *testing.T) {
+		h, _ := NewServiceHandlerWithOptions(slowFunction, WithTimeout(10*time.Millisecond))
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, httptest.NewRequest("GET", "/slowFunction", nil))
+		if recorder.Code != 504 {
+			t.Error("code is not 504, body:", recorder.Body)
+		}
+	})
+
+	t.Run("explicit error wrapping the timeout", func(t *testing.T) {
+		h, _ := NewServiceHandlerWithOptions(func(ctx *ServiceMethodContext, arg *empty) error {
+			return WrapServiceMethodError(slowFunction(ctx, arg), 503, "backend busy")
+		}, WithTimeout(10*time.Millisecond))
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+		if recorder.Code != 503 || !strings.Contains(recorder.Body.String(), "backend busy") {
+			t.Error("code is not 503, body:", recorder.Body)
+		}
+	})
+
+	t.Run("route options override router options", func(t *testing.T) {
+		router, err := NewHTTPRouter([]*Route{
+			{Method: "GET", Path: "/default", Function: e.errorMethod},
+			{Method: "GET", Path: "/problem", Function: e.errorMethod,
+				Options: []HandlerOption{WithResponseRenderer(ProblemJSONRenderer{})}},
+		}, WithResponseRenderer(envelopeRenderer{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("GET", "/default", nil))
+		if !strings.Contains(recorder.Body.String(), "\"success\":false") {
+			t.Error("body is wrong:", recorder.Body)
+		}
+
+		recorder = httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("GET", "/problem", nil))
+		if recorder.Header().Get("Content-Type") != "application/problem+json" {
+			t.Error("content type is wrong:", recorder.Header())
+		}
+	})
+}
+
+func echoFunction(_ *ServiceMethodContext, arg *validatorEnabled) *validatorEnabled {
+	return arg
+}
+
+func TestServiceHandlerBodyDecoders(t *testing.T) {
+	h, _ := NewServiceHandlerWithOptions(echoFunction, WithBodyDecoder("text/plain",
+		func(r *http.Request, arg interface{}) error {
+			arg.(*validatorEnabled).A = 3
+			return nil
+		}))
+
+	multipartBody := "--boundary\r\nContent-Disposition: form-data; name=\"A\"\r\n\r\n5\r\n--boundary--\r\n"
+	cases := []struct {
+		name        string
+		contentType string
+		body        string
+		code        int
+		expected    string
+	}{
+		{"json with charset", "application/json; charset=utf-8", "{\"A\": 1}", 200, "{\"A\":1}"},
+		{"json suffix", "application/merge-patch+json", "{\"A\": 2}", 200, "{\"A\":2}"},
+		{"form", "application/x-www-form-urlencoded", "A=4", 200, "{\"A\":4}"},
+		{"multipart", "multipart/form-data; boundary=boundary", multipartBody, 200, "{\"A\":5}"},
+		{"custom decoder", "text/plain", "anything", 200, "{\"A\":3}"},
+		{"unsupported", "application/xml", "<A>1</A>", 415, ""},
+		{"malformed", "application/", "{\"A\": 1}", 400, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/echoFunction", strings.NewReader(c.body))
+			r.Header.Set("Content-Type", c.contentType)
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, r)
+			if recorder.Code != c.code || (c.expected != "" && strings.TrimSpace(recorder.Body.String()) != c.expected) {
+				t.Errorf("code is not %d or body is wrong, body: %s", c.code, recorder.Body)
+			}
+		})
+	}
+}
+
+type protoResult struct{}
+
+func (*protoResult) Marshal() ([]byte, error) {
+	return []byte{0x08, 0x01}, nil
+}
+
+func protoFunction(*ServiceMethodContext, *empty) *protoResult {
+	return &protoResult{}
+}
+
+type protoMessage struct {
+	A int
+}
+
+func (*protoMessage) ProtoMessage() {}
+
+func protoMessageFunction(*ServiceMethodContext, *empty) *protoMessage {
+	return &protoMessage{1}
+}
+
+func marshalProtoMessage(m ProtoMessage) ([]byte, error) {
+	return []byte{0x08, byte(m.(*protoMessage).A)}, nil
+}
+
+func TestServiceHandlerResponseEncoders(t *testing.T) {
+	h1, _ := NewServiceHandlerWithOptions(emptyFunction)
+	h2, _ := NewServiceHandlerWithOptions(protoFunction)
+	h3, _ := NewServiceHandlerWithOptions(e.errorMethod, WithResponseRenderer(ProblemJSONRenderer{}))
+	h4, _ := NewServiceHandlerWithOptions(protoMessageFunction, WithProtoMarshal(marshalProtoMessage))
+	h5, _ := NewServiceHandlerWithOptions(protoMessageFunction)
+
+	cases := []struct {
+		name        string
+		handler     *ServiceHandler
+		accept      string
+		code        int
+		contentType string
+		body        string
+	}{
+		{"default", h1, "", 200, "application/json", "{\"A\":1}\n"},
+		{"wildcard", h1, "text/html, */*;q=0.1", 200, "application/json", "{\"A\":1}\n"},
+		{"msgpack", h1, "application/msgpack", 200, "application/msgpack", "\x81\xa1A\x01"},
+		{"quality", h1, "application/json;q=0.5, application/x-msgpack", 200, "application/x-msgpack", "\x81\xa1A\x01"},
+		{"protobuf", h2, "application/x-protobuf", 200, "application/x-protobuf", "\x08\x01"},
+		{"not protobuf", h1, "application/x-protobuf", 406, "application/json", ""},
+		{"proto message", h4, "application/protobuf", 200, "application/protobuf", "\x08\x01"},
+		{"proto message without marshal", h5, "application/x-protobuf", 406, "application/json", ""},
+		{"not acceptable", h1, "text/html", 406, "application/json", ""},
+		{"problem as json", h3, "application/json", 500, "application/problem+json", ""},
+		{"problem fallback", h3, "text/html", 500, "application/problem+json", ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Accept", c.accept)
+			recorder := httptest.NewRecorder()
+			c.handler.ServeHTTP(recorder, r)
+			if recorder.Code != c.code || recorder.Header().Get("Content-Type") != c.contentType ||
+				(c.body != "" && recorder.Body.String() != c.body) {
+				t.Errorf("unexpected response %d %s: %q", recorder.Code, recorder.Header().Get("Content-Type"),
+					recorder.Body)
+			}
+		})
+	}
+}
+
+type uploadArgument struct {
+	Title  string          `validate:"required"`
+	Avatar *UploadedFile   `validate:"required"`
+	Photos []*UploadedFile `schema:"photo"`
+}
+
+func uploadFunction(_ *ServiceMethodContext, arg *uploadArgument) (interface{}, error) {
+	f, err := arg.Avatar.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"title": arg.Title, "avatar": string(content), "photos": len(arg.Photos)}, nil
+}
+
+func newMultipartRequest(fields map[string]string, files map[string][]string) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for k, v := range fields {
+		writer.WriteField(k, v)
+	}
+
+	for k, contents := range files {
+		for i, content := range contents {
+			part, _ := writer.CreateFormFile(k, fmt.Sprintf("%s%d.txt", k, i))
+			part.Write([]byte(content))
+		}
+	}
+
+	writer.Close()
+	r := httptest.NewRequest("POST", "/uploadFunction", body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	return r
+}
+
+func TestServiceHandlerUploadedFiles(t *testing.T) {
+	h, _ := NewServiceHandlerWithOptions(uploadFunction, WithMaxFileSize(8))
+
+	t.Run("bind files", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, newMultipartRequest(map[string]string{"Title": "t"},
+			map[string][]string{"avatar": {"face"}, "photo": {"a", "b"}}))
+		expected := "{\"avatar\":\"face\",\"photos\":2,\"title\":\"t\"}"
+		if recorder.Code != 200 || strings.TrimSpace(recorder.Body.String()) != expected {
+			t.Error("code is not 200 or body is wrong, body:", recorder.Body)
+		}
+	})
+
+	t.Run("required file", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, newMultipartRequest(map[string]string{"Title": "t"}, nil))
+		if recorder.Code != 400 || !strings.Contains(recorder.Body.String(), "\"field\":\"Avatar\"") {
+			t.Error("code is not 400 or body is wrong, body:", recorder.Body)
+		}
+	})
+
+	t.Run("file too large", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, newMultipartRequest(map[string]string{"Title": "t"},
+			map[string][]string{"avatar": {"a very large face"}}))
+		if recorder.Code != 413 || !strings.Contains(recorder.Body.String(), "avatar0.txt") {
+			t.Error("code is not 413, body:", recorder.Body)
+		}
+	})
+
+	t.Run("temporary files removed", func(t *testing.T) {
+		dir := t.TempDir()
+		t.Setenv("TMPDIR", dir)
+		spilling, _ := NewServiceHandlerWithOptions(uploadFunction, WithMultipartMaxMemory(1), WithMaxFileSize(8))
+		recorder := httptest.NewRecorder()
+		spilling.ServeHTTP(recorder, newMultipartRequest(map[string]string{"Title": "t"},
+			map[string][]string{"avatar": {"face"}}))
+		files, _ := ioutil.ReadDir(dir)
+		if recorder.Code != 200 || len(files) != 0 {
+			t.Error("temporary files are left:", recorder.Code, recorder.Body, len(files))
+		}
+	})
+}
+
+type looseArgument struct {
+	ID    int64
+	Extra interface{}
+}
+
+func looseFunction(_ *ServiceMethodContext, arg *looseArgument) *looseArgument {
+	return arg
+}
+
+func TestServiceHandlerBodyLimits(t *testing.T) {
+	h1, _ := NewServiceHandlerWithOptions(looseFunction, WithMaxBodySize(32))
+	h2, _ := NewServiceHandlerWithOptions(looseFunction, WithJSONDecodeOptions(JSONDecodeOptions{true, true, true}))
+	h3, _ := NewServiceHandlerWithOptions(looseFunction, WithMaxBodySize(10),
+		WithJSONDecodeOptions(JSONDecodeOptions{true, true, true}))
+
+	cases := []struct {
+		name          string
+		handler       *ServiceHandler
+		body          string
+		unknownLength bool
+		code          int
+		expected      string
+	}{
+		{"small body", h1, "{\"ID\": 1}", false, 200, "{\"ID\":1,\"Extra\":null}"},
+		{"large body", h1, "{\"ID\": 1, \"Extra\": \"0123456789012345678901234567890123456789\"}", false, 413, ""},
+		{"large streaming body", h1, "{\"ID\": 1, \"Extra\": \"0123456789012345678901234567890123456789\"}", true, 413, ""},
+		{"unknown field", h2, "{\"ID\": 1, \"Name\": \"x\"}", false, 400, ""},
+		{"trailing data", h2, "{\"ID\": 1} {}", false, 400, ""},
+		{"trailing whitespace", h2, "{\"ID\": 1}\n", false, 200, "{\"ID\":1,\"Extra\":null}"},
+		{"large body after the value", h3, "{\"ID\": 1} 0123456789", true, 413, ""},
+		{"use number", h2, "{\"Extra\": 9007199254740993}", false, 200, "{\"ID\":0,\"Extra\":9007199254740993}"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/looseFunction", strings.NewReader(c.body))
+			if c.unknownLength {
+				r.ContentLength = -1
+			}
+
+			r.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			c.handler.ServeHTTP(recorder, r)
+			if recorder.Code != c.code || (c.expected != "" && strings.TrimSpace(recorder.Body.String()) != c.expected) {
+				t.Errorf("code is not %d or body is wrong, body: %s", c.code, recorder.Body)
+			}
+		})
+	}
+}
+
+type boundArgument struct {
+	RequestID string `kelly:"header=X-Request-Id"`
+	Session   string `kelly:"cookie=session"`
+	Name      string `kelly:"path"`
+	Page      int    `kelly:"query=p"`
+	Content   string `kelly:"body"`
+	Free      string
+}
+
+func boundFunction(_ *ServiceMethodContext, arg *boundArgument) *boundArgument {
+	return arg
+}
+
+func TestServiceHandlerExplicitSources(t *testing.T) {
+	router := httprouter.New()
+	if err := RegisterFunctionsToHTTPRouter(router, nil, []*Route{
+		{Method: "POST", Path: "/bound/:Name", Function: boundFunction},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("POST", "/bound/path-name?p=3&Page=4&Content=query&RequestID=query&Free=query",
+		strings.NewReader("{\"RequestID\":\"body\",\"Name\":\"body\",\"Page\":5,\"Session\":\"body\"}"))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-Request-Id", "header-id")
+	r.AddCookie(&http.Cookie{Name: "session", Value: "cookie-session"})
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, r)
+	expected := "{\"RequestID\":\"header-id\",\"Session\":\"cookie-session\",\"Name\":\"path-name\",\"Page\":3," +
+		"\"Content\":\"\",\"Free\":\"query\"}"
+	if recorder.Code != 200 || strings.TrimSpace(recorder.Body.String()) != expected {
+		t.Error("code is not 200 or body is wrong, body:", recorder.Body)
+	}
+
+	t.Run("wrong value", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/bound/path-name?p=x", nil)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, r)
+		if recorder.Code != 400 {
+			t.Error("code is not 400, body:", recorder.Body)
+		}
+	})
+
+	t.Run("unknown source", func(t *testing.T) {
+		_, err := NewServiceHandlerWithOptions(func(*ServiceMethodContext, *struct {
+			A string `kelly:"form"`
+		}) error {
+			return nil
+		})
+		if err == nil {
+			t.Error("unknown source is accepted")
+		}
+	})
+}
+
+type precedenceArgument struct {
+	UserName string
+	Age      int
+}
+
+func precedenceFunction(_ *ServiceMethodContext, arg *precedenceArgument) *precedenceArgument {
+	return arg
+}
+
+func TestServiceHandlerSourcePrecedence(t *testing.T) {
+	orders := [][]string{
+		{SourceQuery, SourceBody, SourcePath},
+		{SourceQuery, SourcePath, SourceBody},
+		{SourceBody, SourceQuery, SourcePath},
+		{SourceBody, SourcePath, SourceQuery},
+		{SourcePath, SourceQuery, SourceBody},
+		{SourcePath, SourceBody, SourceQuery},
+	}
+
+	// every non empty subset of the sources providing the field, with differently cased names.
+	subsets := [][]string{
+		{SourceQuery}, {SourceBody}, {SourcePath},
+		{SourceQuery, SourceBody}, {SourceQuery, SourcePath}, {SourceBody, SourcePath},
+		{SourceQuery, SourceBody, SourcePath},
+	}
+
+	for _, policy := range []ConflictPolicy{OverrideByPrecedence, RejectConflicts} {
+		for _, order := range orders {
+			h, err := NewServiceHandlerWithOptions(precedenceFunction, WithSourcePrecedence(order...),
+				WithConflictPolicy(policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, subset := range subsets {
+				name := fmt.Sprintf("policy %d order %v sources %v", policy, order, subset)
+				t.Run(name, func(t *testing.T) {
+					target, body := "/precedenceFunction?page=1", "{\"Age\": 1}"
+					var params httprouter.Params
+					for _, source := range subset {
+						switch source {
+						case SourceQuery:
+							target += "&USERNAME=query"
+						case SourceBody:
+							body = "{\"Age\": 1, \"userName\": \"body\"}"
+						case SourcePath:
+							params = httprouter.Params{{Key: "username", Value: "path"}}
+						}
+					}
+
+					r := httptest.NewRequest("POST", target, strings.NewReader(body))
+					r.Header.Set("Content-Type", "application/json")
+					recorder := httptest.NewRecorder()
+					h.ServeHTTPWithParams(recorder, r, params)
+
+					if policy == RejectConflicts && len(subset) > 1 {
+						if recorder.Code != 400 {
+							t.Error("code is not 400, body:", recorder.Body)
+						}
+
+						return
+					}
+
+					expected := ""
+					for _, source := range order {
+						for _, provided := range subset {
+							if source == provided {
+								expected = source
+							}
+						}
+					}
+
+					if recorder.Code != 200 || !strings.Contains(recorder.Body.String(), "\"UserName\":\""+expected+"\"") {
+						t.Errorf("expected %s, code: %d, body: %s", expected, recorder.Code, recorder.Body)
+					}
+				})
+			}
+		}
+	}
+
+	t.Run("keys of one source", func(t *testing.T) {
+		h, _ := NewServiceHandlerWithOptions(precedenceFunction, WithConflictPolicy(RejectConflicts))
+		r := httptest.NewRequest("POST", "/precedenceFunction?username=a&UserName=b", strings.NewReader("{}"))
+		r.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, r)
+		if recorder.Code != 200 {
+			t.Error("code is not 200, body:", recorder.Body)
+		}
+	})
+
+	t.Run("body too large", func(t *testing.T) {
+		h, _ := NewServiceHandlerWithOptions(precedenceFunction, WithConflictPolicy(RejectConflicts),
+			WithMaxBodySize(8))
+		r := httptest.NewRequest("POST", "/precedenceFunction", strings.NewReader("{\"UserName\": \"body\"}"))
+		r.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, r)
+		if recorder.Code != 413 {
+			t.Error("code is not 413, body:", recorder.Body)
+		}
+	})
+
+	t.Run("invalid precedence", func(t *testing.T) {
+		_, err := NewServiceHandlerWithOptions(precedenceFunction, WithSourcePrecedence(SourceQuery, SourceQuery))
+		if err == nil {
+			t.Error("invalid precedence is accepted")
+		}
+	})
 }
@@ -0,0 +1,86 @@
+package kellyframework
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ServiceMethodError can be implemented by errors returned from service methods to choose the response status
+// code, the error code put in 'msg' and the structured payload put in 'data'. It is also found through wrapped
+// errors, so domain errors can carry it deep inside an error chain.
+type ServiceMethodError interface {
+	error
+	StatusCode() int
+	ErrorCode() string
+	Details() interface{}
+}
+
+type serviceMethodError struct {
+	statusCode int
+	errorCode  string
+	details    interface{}
+	cause      error
+}
+
+func (e *serviceMethodError) Error() string {
+	if e.cause != nil {
+		return e.cause.Error()
+	}
+
+	return fmt.Sprintf("%d %s", e.statusCode, e.errorCode)
+}
+
+func (e *serviceMethodError) StatusCode() int {
+	return e.statusCode
+}
+
+func (e *serviceMethodError) ErrorCode() string {
+	return e.errorCode
+}
+
+func (e *serviceMethodError) Details() interface{} {
+	return e.details
+}
+
+func (e *serviceMethodError) Unwrap() error {
+	return e.cause
+}
+
+func NewServiceMethodError(statusCode int, errorCode string, details interface{}) error {
+	return &serviceMethodError{statusCode, errorCode, details, nil}
+}
+
+func WrapServiceMethodError(err error, statusCode int, errorCode string) error {
+	return &serviceMethodError{statusCode, errorCode, nil, err}
+}
+
+func errorToFormattedResponse(err error) *FormattedResponse {
+	// an explicit ServiceMethodError is kept even when it wraps the deadline error.
+	var se ServiceMethodError
+	if !errors.As(err, &se) {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return &FormattedResponse{504, "service method timeout", err.Error()}
+		}
+
+		return &FormattedResponse{500, "service method error", err.Error()}
+	}
+
+	code := se.StatusCode()
+	if code == 0 {
+		code = 500
+	}
+
+	msg := se.ErrorCode()
+	if msg == "" {
+		msg = http.StatusText(code)
+	}
+
+	data := se.Details()
+	if data == nil {
+		data = err.Error()
+	}
+
+	return &FormattedResponse{code, msg, data}
+}
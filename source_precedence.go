@@ -0,0 +1,143 @@
+package kellyframework
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+type ConflictPolicy int
+
+const (
+	// OverrideByPrecedence lets a higher precedence source override the fields set by lower ones.
+	OverrideByPrecedence ConflictPolicy = iota
+	// RejectConflicts rejects requests setting one field from more than one source with 400.
+	RejectConflicts
+)
+
+var defaultSourcePrecedence = []string{SourceQuery, SourceBody, SourcePath}
+
+// WithSourcePrecedence sets the order the query string, the body and the path params are decoded in, from the
+// lowest precedence to the highest. The default is SourceQuery, SourceBody, SourcePath.
+func WithSourcePrecedence(sources ...string) HandlerOption {
+	return func(h *ServiceHandler) {
+		h.sourcePrecedence = sources
+	}
+}
+
+func WithConflictPolicy(policy ConflictPolicy) HandlerOption {
+	return func(h *ServiceHandler) {
+		h.conflictPolicy = policy
+	}
+}
+
+func checkSourcePrecedence(sources []string) error {
+	seen := make(map[string]bool)
+	for _, source := range sources {
+		if source != SourceQuery && source != SourceBody && source != SourcePath {
+			return fmt.Errorf("%q can not be ordered, only query, body and path can", source)
+		}
+
+		if seen[source] {
+			return fmt.Errorf("source %q is duplicated", source)
+		}
+
+		seen[source] = true
+	}
+
+	if len(seen) != len(defaultSourcePrecedence) {
+		return fmt.Errorf("the precedence should list all of query, body and path")
+	}
+
+	return nil
+}
+
+// sourceConflicts records which sources set each field. Keys of one source setting the same field, e.g. 'a.b' and
+// 'a.c', are not conflicts.
+type sourceConflicts map[string][]string
+
+func (c sourceConflicts) add(source string, fieldNames []string) {
+	for _, name := range fieldNames {
+		sources := c[name]
+		if len(sources) == 0 || sources[len(sources)-1] != source {
+			c[name] = append(sources, source)
+		}
+	}
+}
+
+func (c sourceConflicts) err() error {
+	var conflicts []string
+	for name, sources := range c {
+		if len(sources) > 1 {
+			conflicts = append(conflicts, fmt.Sprintf("%s (%s)", name, strings.Join(sources, ", ")))
+		}
+	}
+
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	sort.Strings(conflicts)
+	return fmt.Errorf("fields set by more than one source: %s", strings.Join(conflicts, "; "))
+}
+
+func valueKeys(values url.Values) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+// peekJSONBodyKeys reads the top level keys of a JSON object body, the body is buffered to be decoded again.
+func peekJSONBodyKeys(r *http.Request) ([]string, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !isJSONMediaType(mediaType) {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadAll(r.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, WrapServiceMethodError(err, http.StatusRequestEntityTooLarge, "request body too large")
+	} else if err != nil {
+		return nil, err
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(data))
+	var object map[string]json.RawMessage
+	if json.Unmarshal(data, &object) != nil {
+		// not an object, the decoder will tell what is wrong.
+		return nil, nil
+	}
+
+	keys := make([]string, 0, len(object))
+	for k := range object {
+		keys = append(keys, k)
+	}
+
+	return keys, nil
+}
+
+// formBodyKeys returns the keys of the form bodies parsed by the built-in decoders.
+func formBodyKeys(r *http.Request) []string {
+	var keys []string
+	if r.MultipartForm != nil {
+		keys = append(keys, valueKeys(r.MultipartForm.Value)...)
+		for k := range r.MultipartForm.File {
+			keys = append(keys, k)
+		}
+	} else if r.PostForm != nil {
+		keys = append(keys, valueKeys(r.PostForm)...)
+	}
+
+	return keys
+}
@@ -0,0 +1,387 @@
+package kellyframework
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TraceID and SpanID are the identifiers of W3C trace context, they are compatible with OpenTelemetry.
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+const (
+	traceparentHeader = "Traceparent"
+	tracestateHeader  = "Tracestate"
+	traceFlagSampled  = 0x01
+)
+
+// SpanContext identifies a span across services, it is carried by the traceparent and tracestate headers.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	TraceFlags byte
+	TraceState string
+	// Remote is set for span contexts extracted from requests.
+	Remote bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+func (sc SpanContext) IsSampled() bool {
+	return sc.TraceFlags&traceFlagSampled != 0
+}
+
+// Traceparent formats the span context as a version 00 traceparent header.
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.TraceFlags)
+}
+
+// ParseTraceparent parses a traceparent header, headers of future versions are accepted as the specification asks.
+func ParseTraceparent(traceparent string) (sc SpanContext, err error) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, fmt.Errorf("invalid traceparent %q", traceparent)
+	}
+
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) || strings.ToLower(traceparent) != traceparent {
+		return sc, fmt.Errorf("invalid traceparent %q", traceparent)
+	}
+
+	var version, flags [1]byte
+	_, err1 := hex.Decode(version[:], []byte(parts[0]))
+	_, err2 := hex.Decode(sc.TraceID[:], []byte(parts[1]))
+	_, err3 := hex.Decode(sc.SpanID[:], []byte(parts[2]))
+	_, err4 := hex.Decode(flags[:], []byte(parts[3]))
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", traceparent)
+	}
+
+	sc.TraceFlags = flags[0]
+	sc.Remote = true
+	return sc, nil
+}
+
+// ExtractTraceContext returns the span context of the traceparent and tracestate headers, or an invalid span context
+// when there is no valid traceparent.
+func ExtractTraceContext(header http.Header) SpanContext {
+	sc, err := ParseTraceparent(header.Get(traceparentHeader))
+	if err != nil {
+		return SpanContext{}
+	}
+
+	sc.TraceState = strings.Join(header[tracestateHeader], ",")
+	return sc
+}
+
+// InjectTraceContext sets the traceparent and tracestate headers of an outgoing request to the span of ctx.
+func InjectTraceContext(ctx context.Context, header http.Header) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+
+	header.Set(traceparentHeader, sc.Traceparent())
+	if sc.TraceState != "" {
+		header.Set(tracestateHeader, sc.TraceState)
+	} else {
+		header.Del(tracestateHeader)
+	}
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext returns a context whose spans are children of sc, e.g. to continue a trace received from
+// a message queue.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, &Span{SpanContext: sc})
+}
+
+// SpanContextFromContext returns the span context of the current span of ctx.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span, ok := ctx.Value(spanContextKey{}).(*Span); ok {
+		return span.SpanContext
+	}
+
+	return SpanContext{}
+}
+
+// SpanKind and SpanStatusCode have the values of OTLP.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+type SpanStatusCode int
+
+const (
+	SpanStatusUnset SpanStatusCode = 0
+	SpanStatusOK    SpanStatusCode = 1
+	SpanStatusError SpanStatusCode = 2
+)
+
+// Span is a timed operation of a trace. The methods of a nil Span do nothing, so that code paths without a Tracer
+// need no checks.
+type Span struct {
+	Name          string
+	Kind          SpanKind
+	SpanContext   SpanContext
+	Parent        SpanContext
+	StartTime     time.Time
+	EndTime       time.Time
+	Attributes    map[string]interface{}
+	StatusCode    SpanStatusCode
+	StatusMessage string
+
+	tracer *Tracer
+	// root collects the ended spans of a trace in this process, they are exported together when root ends.
+	root  *Span
+	mu    sync.Mutex
+	ended []*Span
+}
+
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.Attributes[key] = value
+}
+
+func (s *Span) SetStatus(code SpanStatusCode, message string) {
+	if s == nil {
+		return
+	}
+
+	s.StatusCode = code
+	s.StatusMessage = message
+}
+
+// End records the end time of the span, the spans are queued for export when the first span of the trace in this
+// process ends.
+func (s *Span) End() {
+	if s == nil || s.tracer == nil {
+		return
+	}
+
+	s.root.mu.Lock()
+	if !s.EndTime.IsZero() {
+		s.root.mu.Unlock()
+		return
+	}
+
+	s.EndTime = time.Now()
+	s.root.ended = append(s.root.ended, s)
+	spans := s.root.ended
+	s.root.mu.Unlock()
+
+	if s == s.root && s.SpanContext.IsSampled() {
+		s.tracer.enqueue(spans)
+	}
+}
+
+// hasEnded reports whether the span has ended, s has to be a root span.
+func (s *Span) hasEnded() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.EndTime.IsZero()
+}
+
+// SpanExporter sends ended spans to a tracing backend, see OTLPExporter.
+type SpanExporter interface {
+	ExportSpans(spans []*Span) error
+}
+
+const (
+	tracerQueueSize     = 1024
+	tracerBatchSize     = 512
+	tracerBatchInterval = time.Second
+)
+
+// Tracer starts spans. Requests without a sampled traceparent start new sampled traces, the sampling decision of
+// the caller is followed otherwise.
+//
+// Ended traces are exported in batches by a background goroutine, so requests never wait on the backend. When the
+// exporter falls behind and the queue is full, traces are dropped and counted by Dropped. Call Shutdown before the
+// process exits to export the queued traces.
+type Tracer struct {
+	exporter SpanExporter
+	// ErrorLog receives the export failures.
+	ErrorLog *log.Logger
+	queue    chan []*Span
+	flush    chan chan struct{}
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+	dropped  uint64
+}
+
+func NewTracer(exporter SpanExporter) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		queue:    make(chan []*Span, tracerQueueSize),
+		flush:    make(chan chan struct{}),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Shutdown stops the export goroutine after exporting the queued traces. The traces ending later are dropped. It
+// returns the error of ctx if ctx is done before the export finishes.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.stopOnce.Do(func() {
+		close(t.stop)
+	})
+
+	select {
+	case <-t.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Dropped returns how many traces were dropped because the export queue was full.
+func (t *Tracer) Dropped() uint64 {
+	return atomic.LoadUint64(&t.dropped)
+}
+
+// Flush exports the queued traces and waits for the export to finish.
+func (t *Tracer) Flush() {
+	done := make(chan struct{})
+	select {
+	case t.flush <- done:
+		<-done
+	case <-t.stopped:
+	}
+}
+
+func (t *Tracer) enqueue(spans []*Span) {
+	select {
+	case <-t.stop:
+		atomic.AddUint64(&t.dropped, 1)
+		return
+	default:
+	}
+
+	select {
+	case t.queue <- spans:
+	default:
+		atomic.AddUint64(&t.dropped, 1)
+	}
+}
+
+func (t *Tracer) export(batch []*Span) {
+	if len(batch) == 0 {
+		return
+	}
+
+	err := t.exporter.ExportSpans(batch)
+	if err != nil && t.ErrorLog != nil {
+		t.ErrorLog.Printf("export %d spans failed: %s", len(batch), err)
+	}
+}
+
+func (t *Tracer) run() {
+	var batch []*Span
+	ticker := time.NewTicker(tracerBatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case spans := <-t.queue:
+			batch = append(batch, spans...)
+			if len(batch) >= tracerBatchSize {
+				t.export(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			t.export(batch)
+			batch = nil
+		case done := <-t.flush:
+			t.export(t.drain(batch))
+			batch = nil
+			close(done)
+		case <-t.stop:
+			t.export(t.drain(batch))
+			close(t.stopped)
+			return
+		}
+	}
+}
+
+// drain appends the queued spans to batch without waiting for more.
+func (t *Tracer) drain(batch []*Span) []*Span {
+	for {
+		select {
+		case spans := <-t.queue:
+			batch = append(batch, spans...)
+		default:
+			return batch
+		}
+	}
+}
+
+// WithTracer traces every request with a server span named after the route pattern and child spans for argument
+// parsing, the method call and the response encoding. The span context is on ServiceMethodContext. Handlers used
+// without a router name the spans after the service method.
+func WithTracer(tracer *Tracer) HandlerOption {
+	return func(h *ServiceHandler) {
+		h.tracer = tracer
+	}
+}
+
+func newSpanIDs(traceID *TraceID, spanID *SpanID) {
+	if traceID != nil {
+		if _, err := rand.Read(traceID[:]); err != nil {
+			panic(err)
+		}
+	}
+
+	if _, err := rand.Read(spanID[:]); err != nil {
+		panic(err)
+	}
+}
+
+// Start starts a span as a child of the span of ctx, and returns a context carrying the new span.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	span := &Span{Name: name, Kind: kind, StartTime: time.Now(), Attributes: make(map[string]interface{}), tracer: t}
+	parent, _ := ctx.Value(spanContextKey{}).(*Span)
+	if parent != nil && parent.SpanContext.IsValid() {
+		span.Parent = parent.SpanContext
+		span.SpanContext = parent.SpanContext
+		span.SpanContext.Remote = false
+		newSpanIDs(nil, &span.SpanContext.SpanID)
+	} else {
+		span.SpanContext.TraceFlags = traceFlagSampled
+		newSpanIDs(&span.SpanContext.TraceID, &span.SpanContext.SpanID)
+	}
+
+	span.root = span
+	if parent != nil && parent.tracer == t && !parent.root.hasEnded() {
+		span.root = parent.root
+	}
+
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
@@ -0,0 +1,164 @@
+package kellyframework
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func tracedFunction(ctx *ServiceMethodContext, arg *struct{ Name string }) (string, error) {
+	header := http.Header{}
+	InjectTraceContext(ctx.Context, header)
+	if SpanContextFromContext(ctx.Context) != ctx.SpanContext {
+		return "", NewServiceMethodError(500, "span context mismatch", nil)
+	}
+
+	return header.Get("traceparent") + " " + header.Get("tracestate"), nil
+}
+
+func TestParseTraceparent(t *testing.T) {
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		if _, err := ParseTraceparent(invalid); err == nil {
+			t.Error("invalid traceparent is accepted:", invalid)
+		}
+	}
+
+	sc, err := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future")
+	if err != nil || !sc.IsSampled() || !sc.Remote ||
+		sc.Traceparent() != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Error("traceparent is parsed wrong:", sc, err)
+	}
+}
+
+func TestTracer(t *testing.T) {
+	var exported []*otlpTraceRequest
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		req := &otlpTraceRequest{}
+		if err := json.Unmarshal(body, req); err != nil || r.URL.Path != "/v1/traces" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		exported = append(exported, req)
+	}))
+	defer collector.Close()
+
+	tracer := NewTracer(NewOTLPExporter(collector.URL+"/v1/traces", "hello"))
+	router, err := NewHTTPRouter([]*Route{{Method: "GET", Path: "/hello/:Name", Function: tracedFunction}},
+		WithTracer(tracer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/hello/kelly", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("tracestate", "vendor=value")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	tracer.Flush()
+
+	if len(exported) != 1 {
+		t.Fatal("spans are not exported:", exported)
+	}
+
+	resourceSpans := exported[0].ResourceSpans[0]
+	if *resourceSpans.Resource.Attributes[0].Value.StringValue != "hello" {
+		t.Error("service name is wrong")
+	}
+
+	spans := make(map[string]*otlpSpan)
+	for _, span := range resourceSpans.ScopeSpans[0].Spans {
+		spans[span.Name] = span
+		if span.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || span.TraceState != "vendor=value" {
+			t.Error("span is not in the incoming trace:", span)
+		}
+	}
+
+	server := spans["GET /hello/:Name"]
+	if len(spans) != 4 || server == nil || server.ParentSpanID != "00f067aa0ba902b7" || server.Kind != SpanKindServer {
+		t.Fatal("spans are wrong:", spans)
+	}
+
+	for _, name := range []string{"parse argument", "call tracedFunction", "encode response"} {
+		if spans[name] == nil || spans[name].ParentSpanID != server.SpanID {
+			t.Error("child span is wrong:", name, spans[name])
+		}
+	}
+
+	var attributes = make(map[string]otlpAnyValue)
+	for _, kv := range server.Attributes {
+		attributes[kv.Key] = kv.Value
+	}
+
+	if *attributes["http.route"].StringValue != "/hello/:Name" || *attributes["http.response.status_code"].IntValue != "200" {
+		t.Error("server span attributes are wrong:", server.Attributes)
+	}
+
+	var injected string
+	json.Unmarshal(recorder.Body.Bytes(), &injected)
+	expected := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + spans["call tracedFunction"].SpanID + "-01 vendor=value"
+	if injected != expected {
+		t.Error("injected trace context is wrong:", injected, expected)
+	}
+
+	req = httptest.NewRequest("GET", "/hello/kelly", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	tracer.Flush()
+	if len(exported) != 1 {
+		t.Error("unsampled trace is exported")
+	}
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/hello/kelly", nil))
+	tracer.Flush()
+	if len(exported) != 2 || exported[1].ResourceSpans[0].ScopeSpans[0].Spans[0].TraceID == server.TraceID {
+		t.Error("new trace is wrong")
+	}
+}
+
+func TestTracerDropsWhenQueueFull(t *testing.T) {
+	tracer := &Tracer{queue: make(chan []*Span, 1)}
+	tracer.enqueue(nil)
+	tracer.enqueue(nil)
+	if tracer.Dropped() != 1 {
+		t.Error("dropped count is wrong:", tracer.Dropped())
+	}
+}
+
+type countingExporter struct {
+	spans int
+}
+
+func (e *countingExporter) ExportSpans(spans []*Span) error {
+	e.spans += len(spans)
+	return nil
+}
+
+func TestTracerShutdown(t *testing.T) {
+	exporter := &countingExporter{}
+	tracer := NewTracer(exporter)
+	_, span := tracer.Start(context.Background(), "queued", SpanKindServer)
+	span.End()
+	if err := tracer.Shutdown(context.Background()); err != nil || exporter.spans != 1 {
+		t.Fatal("queued spans are not exported:", exporter.spans, err)
+	}
+
+	_, span = tracer.Start(context.Background(), "late", SpanKindServer)
+	span.End()
+	tracer.Flush()
+	if err := tracer.Shutdown(context.Background()); err != nil || exporter.spans != 1 || tracer.Dropped() != 1 {
+		t.Error("spans ending after the shutdown are not dropped:", exporter.spans, tracer.Dropped(), err)
+	}
+}
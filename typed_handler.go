@@ -0,0 +1,56 @@
+package kellyframework
+
+import (
+	"fmt"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// TypedServiceMethod is the prototype of service methods registered with the generic API. Arg has to be a struct,
+// which is still checked when the handler is created.
+type TypedServiceMethod[Arg any, Res any] func(ctx *ServiceMethodContext, arg *Arg) (Res, error)
+
+// NewRoute returns a Route of a service method whose prototype is checked at compile time. The handler calls the
+// method directly instead of through reflection, and the route can be mixed with the other routes of a router.
+func NewRoute[Arg any, Res any](method string, path string, fn TypedServiceMethod[Arg, Res],
+	opts ...HandlerOption) *Route {
+	return &Route{
+		Method: method,
+		Path:   path,
+		// keep the plain function type, the route generators inspect it.
+		Function: (func(*ServiceMethodContext, *Arg) (Res, error))(fn),
+		Options:  append([]HandlerOption{withTypedCall(fn)}, opts...),
+	}
+}
+
+// Handle registers a service method whose prototype is checked at compile time to the router, the router should
+// be created by NewHTTPRouter or registered with ServiceHandlerAccessLogRowFillerContextKey.
+func Handle[Arg any, Res any](r *httprouter.Router, method string, path string, fn TypedServiceMethod[Arg, Res],
+	opts ...HandlerOption) error {
+	return RegisterFunctionsToHTTPRouter(r, ServiceHandlerAccessLogRowFillerContextKey,
+		[]*Route{NewRoute(method, path, fn)}, opts...)
+}
+
+func withTypedCall[Arg any, Res any](fn TypedServiceMethod[Arg, Res]) HandlerOption {
+	return func(h *ServiceHandler) {
+		h.method.call = func(ctx *ServiceMethodContext, arg interface{}) (interface{}, error) {
+			typedArg, ok := arg.(*Arg)
+			if !ok {
+				return nil, fmt.Errorf("the argument should be type %T, not %T", typedArg, arg)
+			}
+
+			return fn(ctx, typedArg)
+		}
+	}
+}
+
+func doTypedServiceMethodCall(method *serviceMethod, ctx *ServiceMethodContext, arg interface{}) (
+	result interface{}, err error) {
+	defer func() {
+		if panicInfo := recover(); panicInfo != nil {
+			result, err = nil, newPanicStack(panicInfo)
+		}
+	}()
+
+	return method.call(ctx, arg)
+}
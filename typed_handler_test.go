@@ -0,0 +1,69 @@
+package kellyframework
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type typedArgument struct {
+	Name string `validate:"required"`
+}
+
+type typedResult struct {
+	Greeting string `json:"greeting"`
+}
+
+func typedFunction(ctx *ServiceMethodContext, arg *typedArgument) (*typedResult, error) {
+	switch arg.Name {
+	case "nobody":
+		return nil, NewServiceMethodError(http.StatusNotFound, "not found", nil)
+	case "panic":
+		panic("boom")
+	}
+
+	return &typedResult{"hello " + arg.Name}, nil
+}
+
+func TestHandle(t *testing.T) {
+	router := httprouter.New()
+	err := Handle(router, "GET", "/hello/:Name", typedFunction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = RegisterFunctionsToHTTPRouter(router, nil, []*Route{
+		NewRoute("GET", "/typed", typedFunction, WithTimeout(0)),
+		{Method: "GET", Path: "/reflected", Function: typedFunction},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		target string
+		status int
+		body   string
+	}{
+		{"/hello/kelly", http.StatusOK, `{"greeting":"hello kelly"}`},
+		{"/typed?Name=kelly", http.StatusOK, `{"greeting":"hello kelly"}`},
+		{"/reflected?Name=kelly", http.StatusOK, `{"greeting":"hello kelly"}`},
+		{"/typed", http.StatusBadRequest, `"tag":"required"`},
+		{"/hello/nobody", http.StatusNotFound, `"msg":"not found"`},
+		{"/typed?Name=panic", http.StatusInternalServerError, `"msg":"service method panicked"`},
+	} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("GET", c.target, nil))
+		if recorder.Code != c.status || !strings.Contains(recorder.Body.String(), c.body) {
+			t.Error(c.target, "response is wrong:", recorder.Code, recorder.Body)
+		}
+	}
+
+	handler, err := NewServiceHandlerWithOptions(typedFunction, NewRoute("GET", "/", typedFunction).Options...)
+	if err != nil || handler.method.call == nil {
+		t.Error("typed call is not set:", err)
+	}
+}
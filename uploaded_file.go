@@ -0,0 +1,208 @@
+package kellyframework
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// UploadedFile is a file part of a 'multipart/form-data' request body. Argument struct fields of type *UploadedFile,
+// []*UploadedFile, *multipart.FileHeader or []*multipart.FileHeader are bound to the file parts having the same
+// form name as the field, which is the 'schema' tag or the field name.
+type UploadedFile struct {
+	*multipart.FileHeader
+}
+
+var uploadedFileType = reflect.TypeOf((*UploadedFile)(nil))
+var fileHeaderType = reflect.TypeOf((*multipart.FileHeader)(nil))
+
+// WithMultipartMaxMemory sets how many bytes of a multipart body are kept in memory, the rest of the file parts
+// are stored in temporary files. The default is 32MB.
+func WithMultipartMaxMemory(maxMemory int64) HandlerOption {
+	return func(h *ServiceHandler) {
+		h.multipartMaxMemory = maxMemory
+	}
+}
+
+// WithMaxFileSize rejects multipart requests having any file part larger than maxSize bytes with 413.
+func WithMaxFileSize(maxSize int64) HandlerOption {
+	return func(h *ServiceHandler) {
+		h.maxFileSize = maxSize
+	}
+}
+
+func formFieldName(field reflect.StructField) string {
+	name := strings.SplitN(field.Tag.Get("schema"), ",", 2)[0]
+	if name == "" {
+		return field.Name
+	}
+
+	return name
+}
+
+func findFileHeaders(files map[string][]*multipart.FileHeader, name string) []*multipart.FileHeader {
+	if headers, ok := files[name]; ok {
+		return headers
+	}
+
+	// form names match case insensitively like the other fields decoded by gorilla/schema.
+	for k, headers := range files {
+		if strings.EqualFold(k, name) {
+			return headers
+		}
+	}
+
+	return nil
+}
+
+func bindUploadedFiles(v reflect.Value, files map[string][]*multipart.FileHeader) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fv := v.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			bindUploadedFiles(fv, files)
+			continue
+		}
+
+		if field.PkgPath != "" || field.Tag.Get("schema") == "-" {
+			continue
+		}
+
+		headers := findFileHeaders(files, formFieldName(field))
+		if len(headers) == 0 {
+			continue
+		}
+
+		switch field.Type {
+		case uploadedFileType:
+			fv.Set(reflect.ValueOf(&UploadedFile{headers[0]}))
+		case fileHeaderType:
+			fv.Set(reflect.ValueOf(headers[0]))
+		case reflect.SliceOf(uploadedFileType):
+			uploaded := make([]*UploadedFile, 0, len(headers))
+			for _, header := range headers {
+				uploaded = append(uploaded, &UploadedFile{header})
+			}
+
+			fv.Set(reflect.ValueOf(uploaded))
+		case reflect.SliceOf(fileHeaderType):
+			fv.Set(reflect.ValueOf(headers))
+		}
+	}
+}
+
+// fileTooLargeError stops the reading of a multipart body at the first file part larger than the limit.
+type fileTooLargeError struct {
+	filename string
+}
+
+func (e *fileTooLargeError) Error() string {
+	return "file too large: " + e.filename
+}
+
+// copyLimitedParts copies the parts of a multipart body unchanged, failing as soon as a file part has more than
+// maxSize bytes.
+func copyLimitedParts(mr *multipart.Reader, mw *multipart.Writer, maxSize int64) error {
+	for {
+		part, err := mr.NextRawPart()
+		if err == io.EOF {
+			return mw.Close()
+		}
+
+		if err != nil {
+			return err
+		}
+
+		w, err := mw.CreatePart(part.Header)
+		if err != nil {
+			return err
+		}
+
+		if part.FileName() == "" {
+			_, err = io.Copy(w, part)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		n, err := io.Copy(w, io.LimitReader(part, maxSize+1))
+		if err != nil {
+			return err
+		}
+
+		if n > maxSize {
+			return &fileTooLargeError{part.FileName()}
+		}
+	}
+}
+
+func (h *ServiceHandler) readMultipartForm(r *http.Request) (*multipart.Form, error) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, http.ErrMissingBoundary
+	}
+
+	if h.maxFileSize <= 0 {
+		return multipart.NewReader(r.Body, boundary).ReadForm(h.multipartMaxMemory)
+	}
+
+	// the body is streamed through a pipe checking the size of every file part, so that a large file is rejected
+	// before it is stored.
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mw := multipart.NewWriter(pw)
+		err := mw.SetBoundary(boundary)
+		if err == nil {
+			err = copyLimitedParts(multipart.NewReader(r.Body, boundary), mw, h.maxFileSize)
+		}
+
+		pw.CloseWithError(err)
+	}()
+
+	form, err := multipart.NewReader(pr, boundary).ReadForm(h.multipartMaxMemory)
+	// stop the copying and wait for it, so that the body is not read after the handler returned.
+	if err != nil {
+		pr.CloseWithError(err)
+	} else {
+		pr.Close()
+	}
+
+	<-done
+	return form, err
+}
+
+func (h *ServiceHandler) decodeMultipartBody(r *http.Request, arg interface{}) error {
+	form, err := h.readMultipartForm(r)
+	var tooLarge *fileTooLargeError
+	if errors.As(err, &tooLarge) {
+		return NewServiceMethodError(http.StatusRequestEntityTooLarge, "file too large", tooLarge.filename)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// the temporary files are removed by serveServiceMethod after the method returned.
+	r.MultipartForm = form
+	err = formDecoder.Decode(arg, form.Value)
+	if err != nil {
+		return err
+	}
+
+	bindUploadedFiles(reflect.ValueOf(arg).Elem(), form.File)
+	return nil
+}
@@ -16,6 +16,10 @@ func (l *methodCallLogger) Record(field string, value string) {
 	l.row.SetRowField(field, value)
 }
 
+func (l *methodCallLogger) RecordValue(field string, value interface{}) {
+	l.row.SetRowValue(field, value)
+}
+
 func ServiceHandlerAccessLogRowFillerFactory(row *AccessLogRow) AccessLogRowFiller {
 	return &methodCallLogger{row}
 }
@@ -26,24 +30,33 @@ type Route struct {
 	Function           interface{}
 	BypassRequestBody  bool
 	BypassResponseBody bool
+	// Options are applied after the router wide options.
+	Options []HandlerOption
+	// Middleware runs after the middleware given by the options.
+	Middleware []Middleware
 }
 
-func RegisterFunctionsToHTTPRouter(r *httprouter.Router, loggerContextKey interface{}, routes []*Route) error {
+func RegisterFunctionsToHTTPRouter(r *httprouter.Router, loggerContextKey interface{}, routes []*Route,
+	opts ...HandlerOption) error {
 	for _, rt := range routes {
-		handler, err := NewServiceHandler(rt.Function, loggerContextKey, rt.BypassRequestBody, rt.BypassResponseBody)
+		handlerOpts := append(append([]HandlerOption{}, opts...), rt.Options...)
+		handler, err := NewServiceHandler(rt.Function, loggerContextKey, rt.BypassRequestBody, rt.BypassResponseBody,
+			append(handlerOpts, WithMiddleware(rt.Middleware...))...)
 		if err != nil {
 			return err
 		}
 
+		handler.route = rt
+
 		r.Handle(rt.Method, rt.Path, handler.ServeHTTPWithParams)
 	}
 
 	return nil
 }
 
-func NewHTTPRouter(routes []*Route) (*httprouter.Router, error) {
+func NewHTTPRouter(routes []*Route, opts ...HandlerOption) (*httprouter.Router, error) {
 	router := httprouter.New()
-	err := RegisterFunctionsToHTTPRouter(router, ServiceHandlerAccessLogRowFillerContextKey, routes)
+	err := RegisterFunctionsToHTTPRouter(router, ServiceHandlerAccessLogRowFillerContextKey, routes, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -51,8 +64,9 @@ func NewHTTPRouter(routes []*Route) (*httprouter.Router, error) {
 	return router, nil
 }
 
-func NewLoggingHTTPRouter(routes []*Route, loggingHeaders []string, logWriter io.Writer) (http.Handler, error) {
-	router, err := NewHTTPRouter(routes)
+func NewLoggingHTTPRouter(routes []*Route, loggingHeaders []string, logWriter io.Writer,
+	opts ...HandlerOption) (http.Handler, error) {
+	router, err := NewHTTPRouter(routes, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,235 @@
+package kellyframework
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/zh"
+	"github.com/go-playground/universal-translator"
+	"gopkg.in/go-playground/validator.v9"
+	entranslations "gopkg.in/go-playground/validator.v9/translations/en"
+)
+
+// FieldValidationError describes one failed validation rule of the argument struct. Field is the JSON path of the
+// field, e.g. 'items[0].name'.
+type FieldValidationError struct {
+	Field   string `json:"field"`
+	Tag     string `json:"tag"`
+	Param   string `json:"param"`
+	Message string `json:"message"`
+}
+
+type validationTranslator struct {
+	*ut.UniversalTranslator
+}
+
+var zhTranslations = map[string]string{
+	"required": "{0}为必填字段",
+	"len":      "{0}的长度必须是{1}",
+	"min":      "{0}的最小值或最小长度为{1}",
+	"max":      "{0}的最大值或最大长度为{1}",
+	"eq":       "{0}不等于{1}",
+	"ne":       "{0}不能等于{1}",
+	"lt":       "{0}必须小于{1}",
+	"lte":      "{0}必须小于或等于{1}",
+	"gt":       "{0}必须大于{1}",
+	"gte":      "{0}必须大于或等于{1}",
+	"oneof":    "{0}必须是[{1}]中的一个",
+	"email":    "{0}必须是一个有效的邮箱",
+	"url":      "{0}必须是一个有效的URL",
+	"numeric":  "{0}必须是一个有效的数值",
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+	if name == "" || name == "-" {
+		return field.Name
+	}
+
+	return name
+}
+
+// NewValidator returns a validator which reports fields by their JSON names. Register custom tags, aliases and
+// struct level validations on it and pass it to WithValidator, so all handlers share the rules and the cache.
+func NewValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(jsonFieldName)
+	return v
+}
+
+var defaultValidatorOnce sync.Once
+var defaultValidator *validator.Validate
+var defaultValidationTranslator *validationTranslator
+
+func sharedDefaultValidator() (*validator.Validate, *validationTranslator) {
+	defaultValidatorOnce.Do(func() {
+		defaultValidator = NewValidator()
+		defaultValidationTranslator = mustNewValidationTranslator(defaultValidator)
+	})
+
+	return defaultValidator, defaultValidationTranslator
+}
+
+// WithValidator makes handlers validate arguments with v instead of the shared default validator.
+func WithValidator(v *validator.Validate) HandlerOption {
+	translator := mustNewValidationTranslator(v)
+	return func(h *ServiceHandler) {
+		h.validator = v
+		h.translator = translator
+	}
+}
+
+func registerZhTranslations(v *validator.Validate, trans ut.Translator) error {
+	for tag, text := range zhTranslations {
+		text := text
+		err := v.RegisterTranslation(tag, trans, func(trans ut.Translator) error {
+			return trans.Add(tag, text, false)
+		}, func(trans ut.Translator, fe validator.FieldError) string {
+			msg, err := trans.T(fe.Tag(), fe.Field(), fe.Param())
+			if err != nil {
+				return fmt.Sprintf("%s failed on the '%s' tag", fe.Field(), fe.Tag())
+			}
+
+			return msg
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newValidationTranslator registers english and chinese messages to the validator, english is the fallback.
+func newValidationTranslator(v *validator.Validate) (*validationTranslator, error) {
+	uni := ut.New(en.New(), en.New(), zh.New())
+	enTrans, _ := uni.GetTranslator("en")
+	err := entranslations.RegisterDefaultTranslations(v, enTrans)
+	if err != nil {
+		return nil, err
+	}
+
+	zhTrans, _ := uni.GetTranslator("zh")
+	err = registerZhTranslations(v, zhTrans)
+	if err != nil {
+		return nil, err
+	}
+
+	return &validationTranslator{uni}, nil
+}
+
+func mustNewValidationTranslator(v *validator.Validate) *validationTranslator {
+	translator, err := newValidationTranslator(v)
+	if err != nil {
+		panic(err)
+	}
+
+	return translator
+}
+
+// acceptedLocales returns the locales in the Accept-Language header ordered by quality, each full locale is
+// followed by its base language, e.g. 'zh-CN' gives 'zh_CN' and 'zh'.
+func acceptedLocales(header string) []string {
+	type weighted struct {
+		locale  string
+		quality float64
+	}
+
+	var candidates []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		if fields[0] == "" || fields[0] == "*" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+
+		candidates = append(candidates, weighted{strings.Replace(fields[0], "-", "_", -1), quality})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].quality > candidates[j].quality
+	})
+
+	var result []string
+	for _, c := range candidates {
+		result = append(result, c.locale)
+		if i := strings.Index(c.locale, "_"); i > 0 {
+			result = append(result, c.locale[:i])
+		}
+	}
+
+	return result
+}
+
+func (t *validationTranslator) translator(r *http.Request) ut.Translator {
+	trans, _ := t.FindTranslator(acceptedLocales(r.Header.Get("Accept-Language"))...)
+	return trans
+}
+
+// jsonNamespace converts a struct namespace like 'arg.Items[0].Name' to the JSON path like 'items[0].name'.
+func jsonNamespace(argType reflect.Type, structNamespace string) string {
+	segments := strings.Split(structNamespace, ".")
+	if len(segments) > 1 {
+		// the first segment is the name of the argument struct itself.
+		segments = segments[1:]
+	}
+
+	t := argType
+	for i, segment := range segments {
+		name, index := segment, ""
+		if j := strings.Index(segment, "["); j >= 0 {
+			name, index = segment[:j], segment[j:]
+		}
+
+		for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array ||
+			t.Kind() == reflect.Map) {
+			t = t.Elem()
+		}
+
+		if t == nil || t.Kind() != reflect.Struct {
+			t = nil
+			continue
+		}
+
+		field, ok := t.FieldByName(name)
+		if !ok {
+			t = nil
+			continue
+		}
+
+		segments[i] = jsonFieldName(field) + index
+		t = field.Type
+	}
+
+	return strings.Join(segments, ".")
+}
+
+func fieldValidationErrors(argType reflect.Type, errs validator.ValidationErrors,
+	trans ut.Translator) []*FieldValidationError {
+	result := make([]*FieldValidationError, 0, len(errs))
+	for _, fe := range errs {
+		result = append(result, &FieldValidationError{
+			jsonNamespace(argType, fe.StructNamespace()),
+			fe.Tag(),
+			fe.Param(),
+			fe.Translate(trans),
+		})
+	}
+
+	return result
+}